@@ -74,15 +74,11 @@ type Agent struct {
 	Longitude       float64 `bson:"longitude" json:"longitude"`
 }
 
-func Parse(db *database.Database, r *http.Request) (agnt *Agent, err error) {
+func ParseIp(db *database.Database, ip string) (agnt *Agent, err error) {
 	if settings.System.Demo {
 		return
 	}
 
-	client := parser.Parse(r.UserAgent())
-
-	ip := node.Self.GetRemoteAddr(r)
-
 	ge, err := geo.Get(db, ip)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -106,6 +102,23 @@ func Parse(db *database.Database, r *http.Request) (agnt *Agent, err error) {
 		Latitude:      ge.Latitude,
 	}
 
+	return
+}
+
+func Parse(db *database.Database, r *http.Request) (agnt *Agent, err error) {
+	if settings.System.Demo {
+		return
+	}
+
+	client := parser.Parse(r.UserAgent())
+
+	ip := node.Self.GetRemoteAddr(r)
+
+	agnt, err = ParseIp(db, ip)
+	if err != nil || agnt == nil {
+		return
+	}
+
 	switch client.Os.Family {
 	case "Android":
 		switch client.Os.Major {
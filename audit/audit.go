@@ -13,7 +13,7 @@ type Fields map[string]interface{}
 
 type Audit struct {
 	Id        bson.ObjectId `bson:"_id,omitempty" json:"id"`
-	User      bson.ObjectId `bson:"u" json:"user"`
+	User      bson.ObjectId `bson:"u,omitempty" json:"user"`
 	Timestamp time.Time     `bson:"t" json:"timestamp"`
 	Type      string        `bson:"y" json:"type"`
 	Fields    Fields        `bson:"f" json:"fields"`
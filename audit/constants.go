@@ -15,4 +15,21 @@ const (
 	SshDeny                   = "ssh_deny"
 	KeybaseAssociationApprove = "keybase_association_approve"
 	KeybaseAssociationDeny    = "keybase_association_deny"
+	AuthorityKeyImport        = "authority_key_import"
+	AuthorityRotateStage      = "authority_rotate_stage"
+	AuthorityRotateSwitch     = "authority_rotate_switch"
+	AuthorityRotateCancel     = "authority_rotate_cancel"
+	AuthorityRotateRetire     = "authority_rotate_retire"
+	EmergencySign             = "emergency_sign"
+	SshKeyRegister            = "ssh_key_register"
+	SshKeyApprove             = "ssh_key_approve"
+	SshKeyRemove              = "ssh_key_remove"
+	BastionAuthFailed         = "bastion_auth_failed"
+	BastionForward            = "bastion_forward"
+	BastionForwardDeny        = "bastion_forward_deny"
+	BastionSession            = "bastion_session"
+	BastionSessionDeny        = "bastion_session_deny"
+	SshRecordingDownload      = "ssh_recording_download"
+	SshRecordingHold          = "ssh_recording_hold"
+	SshRecordingRemove        = "ssh_recording_remove"
 )
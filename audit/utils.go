@@ -1,12 +1,16 @@
 package audit
 
 import (
+	"encoding/json"
+	"github.com/dropbox/godropbox/errors"
 	"github.com/hillrnate/pritunl-zero/agent"
 	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/errortypes"
 	"github.com/hillrnate/pritunl-zero/settings"
 	"github.com/hillrnate/pritunl-zero/utils"
 	"gopkg.in/mgo.v2/bson"
 	"net/http"
+	"os"
 	"time"
 )
 
@@ -87,3 +91,85 @@ func New(db *database.Database, r *http.Request,
 
 	return
 }
+
+func NewRemote(db *database.Database, ip string, userId bson.ObjectId,
+	typ string, fields Fields) (err error) {
+
+	if settings.System.Demo {
+		return
+	}
+
+	agnt, err := agent.ParseIp(db, ip)
+	if err != nil {
+		return
+	}
+
+	adt := &Audit{
+		User:      userId,
+		Timestamp: time.Now(),
+		Type:      typ,
+		Fields:    fields,
+		Agent:     agnt,
+	}
+
+	err = adt.Insert(db)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func NewSystem(db *database.Database, typ string, fields Fields) (err error) {
+	if settings.System.Demo {
+		return
+	}
+
+	adt := &Audit{
+		Timestamp: time.Now(),
+		Type:      typ,
+		Fields:    fields,
+	}
+
+	err = adt.Insert(db)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func NewLocal(path, typ string, fields Fields) (err error) {
+	adt := &Audit{
+		Timestamp: time.Now(),
+		Type:      typ,
+		Fields:    fields,
+	}
+
+	data, err := json.Marshal(adt)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "audit: Failed to marshal entry"),
+		}
+		return
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "audit: Failed to open local audit file"),
+		}
+		return
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "audit: Failed to write local audit file"),
+		}
+		return
+	}
+
+	return
+}
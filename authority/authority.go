@@ -1,8 +1,10 @@
 package authority
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/subtle"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
@@ -17,11 +19,12 @@ import (
 	"github.com/hillrnate/pritunl-zero/user"
 	"github.com/hillrnate/pritunl-zero/utils"
 	"golang.org/x/crypto/ssh"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
-	"hash/fnv"
 	"net"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -32,6 +35,10 @@ var (
 	}
 )
 
+type serialData struct {
+	SerialCounter int64 `bson:"serial_counter"`
+}
+
 type validateData struct {
 	PublicKey string `bson:"public_key" json:"public_key"`
 }
@@ -40,22 +47,57 @@ type Info struct {
 	KeyAlg string `bson:"key_alg" json:"key_alg"`
 }
 
+type RoleAddress struct {
+	Role    string   `bson:"role" json:"role"`
+	Address []string `bson:"address" json:"address"`
+}
+
+type PrincipalMapping struct {
+	Role      string `bson:"role" json:"role"`
+	Principal string `bson:"principal" json:"principal"`
+}
+
 type Authority struct {
-	Id                 bson.ObjectId `bson:"_id,omitempty" json:"id"`
-	Name               string        `bson:"name" json:"name"`
-	Type               string        `bson:"type" json:"type"`
-	Info               *Info         `bson:"info" json:"info"`
-	MatchRoles         bool          `bson:"match_roles" json:"match_roles"`
-	Roles              []string      `bson:"roles" json:"roles"`
-	Expire             int           `bson:"expire" json:"expire"`
-	HostExpire         int           `bson:"host_expire" json:"host_expire"`
-	PrivateKey         string        `bson:"private_key" json:"-"`
-	PublicKey          string        `bson:"public_key" json:"public_key"`
-	HostDomain         string        `bson:"host_domain" json:"host_domain"`
-	HostProxy          string        `bson:"host_proxy" json:"host_proxy"`
-	HostCertificates   bool          `bson:"host_certificates" json:"host_certificates"`
-	StrictHostChecking bool          `bson:"strict_host_checking" json:"strict_host_checking"`
-	HostTokens         []string      `bson:"host_tokens" json:"host_tokens"`
+	Id                 bson.ObjectId       `bson:"_id,omitempty" json:"id"`
+	Name               string              `bson:"name" json:"name"`
+	Type               string              `bson:"type" json:"type"`
+	Info               *Info               `bson:"info" json:"info"`
+	MatchRoles         bool                `bson:"match_roles" json:"match_roles"`
+	Roles              []string            `bson:"roles" json:"roles"`
+	Expire             int                 `bson:"expire" json:"expire"`
+	HostExpire         int                 `bson:"host_expire" json:"host_expire"`
+	PrivateKey         string              `bson:"private_key" json:"-"`
+	PublicKey          string              `bson:"public_key" json:"public_key"`
+	HostDomain         string              `bson:"host_domain" json:"host_domain"`
+	HostProxy          string              `bson:"host_proxy" json:"host_proxy"`
+	HostCertificates   bool                `bson:"host_certificates" json:"host_certificates"`
+	StrictHostChecking bool                `bson:"strict_host_checking" json:"strict_host_checking"`
+	RegisteredKeys     bool                `bson:"registered_keys" json:"registered_keys"`
+	KeyApproval        bool                `bson:"key_approval" json:"key_approval"`
+	RecordingRequired  bool                `bson:"recording_required" json:"recording_required"`
+	HostTokens         []*HostToken        `bson:"host_tokens" json:"host_tokens"`
+	HostPrincipals     []string            `bson:"host_principals" json:"host_principals"`
+	HostChallengeHttps bool                `bson:"host_challenge_https" json:"host_challenge_https"`
+	HostChallengeCa    string              `bson:"host_challenge_ca" json:"host_challenge_ca"`
+	Extensions         []string            `bson:"extensions" json:"extensions"`
+	ForceCommand       string              `bson:"force_command" json:"force_command"`
+	SourceAddress      []string            `bson:"source_address" json:"source_address"`
+	SourceClientIp     bool                `bson:"source_client_ip" json:"source_client_ip"`
+	RoleAddresses      []*RoleAddress      `bson:"role_addresses" json:"role_addresses"`
+	PrincipalMappings  []*PrincipalMapping `bson:"principal_mappings" json:"principal_mappings"`
+	RotateState        string              `bson:"rotate_state" json:"rotate_state"`
+	RotateTimestamp    time.Time           `bson:"rotate_timestamp" json:"rotate_timestamp"`
+	NextInfo           *Info               `bson:"next_info" json:"next_info"`
+	NextPrivateKey     string              `bson:"next_private_key" json:"-"`
+	NextPublicKey      string              `bson:"next_public_key" json:"next_public_key"`
+	PreviousPublicKey  string              `bson:"previous_public_key" json:"previous_public_key"`
+	Pkcs11Module       string              `bson:"pkcs11_module" json:"pkcs11_module"`
+	Pkcs11Token        string              `bson:"pkcs11_token" json:"pkcs11_token"`
+	Pkcs11Key          string              `bson:"pkcs11_key" json:"pkcs11_key"`
+	Pkcs11Pin          string              `bson:"pkcs11_pin" json:"-"`
+	RemoteUrl          string              `bson:"remote_url" json:"remote_url"`
+	RemoteToken        string              `bson:"remote_token" json:"-"`
+	RemoteCa           string              `bson:"remote_ca" json:"remote_ca"`
 }
 
 func (a *Authority) GetDomain(hostname string) string {
@@ -71,175 +113,813 @@ func (a *Authority) GenerateRsaPrivateKey() (err error) {
 	a.Info = &Info{
 		KeyAlg: "RSA 4096",
 	}
-	a.PrivateKey = strings.TrimSpace(string(privKeyBytes))
-	a.PublicKey = strings.TrimSpace(string(pubKeyBytes))
+	a.PrivateKey = strings.TrimSpace(string(privKeyBytes))
+	a.PublicKey = strings.TrimSpace(string(pubKeyBytes))
+
+	return
+}
+
+func (a *Authority) GenerateEcPrivateKey() (err error) {
+	privKeyBytes, pubKeyBytes, err := GenerateEcKey()
+	if err != nil {
+		return
+	}
+
+	a.Info = &Info{
+		KeyAlg: "EC P384",
+	}
+	a.PrivateKey = strings.TrimSpace(string(privKeyBytes))
+	a.PublicKey = strings.TrimSpace(string(pubKeyBytes))
+
+	return
+}
+
+func (a *Authority) GenerateEdPrivateKey() (err error) {
+	privKeyBytes, pubKeyBytes, err := GenerateEdKey()
+	if err != nil {
+		return
+	}
+
+	a.Info = &Info{
+		KeyAlg: "ED25519",
+	}
+	a.PrivateKey = strings.TrimSpace(string(privKeyBytes))
+	a.PublicKey = strings.TrimSpace(string(pubKeyBytes))
+
+	return
+}
+
+func (a *Authority) GeneratePrivateKey(keyAlg string) (err error) {
+	switch keyAlg {
+	case Rsa4096, "":
+		err = a.GenerateRsaPrivateKey()
+		break
+	case EcP384:
+		err = a.GenerateEcPrivateKey()
+		break
+	case Ed25519:
+		err = a.GenerateEdPrivateKey()
+		break
+	default:
+		err = &errortypes.ParseError{
+			errors.Newf("authority: Unknown key algorithm '%s'", keyAlg),
+		}
+	}
+
+	return
+}
+
+func (a *Authority) ImportPrivateKey(data, passphrase string) (
+	errData *errortypes.ErrorData, err error) {
+
+	key, err := ImportKey(data, passphrase)
+	if err != nil {
+		errData = &errortypes.ErrorData{
+			Error:   "private_key_invalid",
+			Message: "Failed to parse private key, check passphrase",
+		}
+		err = nil
+		return
+	}
+
+	if !KeyStrong(key) {
+		errData = &errortypes.ErrorData{
+			Error:   "private_key_weak",
+			Message: "Private key must be RSA 2048, EC P384 or stronger",
+		}
+		return
+	}
+
+	privKeyBytes, pubKeyBytes, keyAlg, err := EncodePrivateKey(key)
+	if err != nil {
+		errData = &errortypes.ErrorData{
+			Error:   "private_key_unsupported",
+			Message: "Private key type is not supported",
+		}
+		err = nil
+		return
+	}
+
+	a.Info = &Info{
+		KeyAlg: keyAlg,
+	}
+	a.PrivateKey = strings.TrimSpace(string(privKeyBytes))
+	a.PublicKey = strings.TrimSpace(string(pubKeyBytes))
+	a.RotateClear()
+
+	return
+}
+
+func (a *Authority) GetHostDomain() string {
+	if a.HostDomain == "" {
+		return ""
+	}
+
+	domain := "*." + a.HostDomain
+
+	if a.HostProxy != "" {
+		hostProxy := strings.SplitN(a.HostProxy, "@", 2)
+		domain += " !" + hostProxy[len(hostProxy)-1]
+	}
+
+	return domain
+}
+
+func (a *Authority) GetPublicKeys() (pubKeys []string) {
+	pubKeys = []string{
+		a.PublicKey,
+	}
+
+	if a.NextPublicKey != "" {
+		pubKeys = append(pubKeys, a.NextPublicKey)
+	}
+	if a.PreviousPublicKey != "" {
+		pubKeys = append(pubKeys, a.PreviousPublicKey)
+	}
+
+	return
+}
+
+func (a *Authority) GetCertAuthority() (certAuthrs []string) {
+	certAuthrs = []string{}
+
+	if a.HostDomain == "" {
+		return
+	}
+
+	for _, pubKey := range a.GetPublicKeys() {
+		certAuthrs = append(certAuthrs, fmt.Sprintf(
+			"@cert-authority *.%s %s", a.HostDomain, pubKey))
+	}
+
+	return
+}
+
+func (a *Authority) LoadPkcs11() (errData *errortypes.ErrorData, err error) {
+	a.Pkcs11Module = strings.TrimSpace(a.Pkcs11Module)
+	a.Pkcs11Token = strings.TrimSpace(a.Pkcs11Token)
+	a.Pkcs11Key = strings.TrimSpace(a.Pkcs11Key)
+
+	if a.Pkcs11Module == "" || a.Pkcs11Token == "" || a.Pkcs11Key == "" {
+		errData = &errortypes.ErrorData{
+			Error:   "pkcs11_required",
+			Message: "PKCS#11 module, token and key label must be set",
+		}
+		return
+	}
+
+	key, keyAlg, e := NewPkcs11Key(
+		a.Pkcs11Module, a.Pkcs11Token, a.Pkcs11Pin, a.Pkcs11Key)
+	if e != nil {
+		logrus.WithFields(logrus.Fields{
+			"authority_id": a.Id.Hex(),
+			"module":       a.Pkcs11Module,
+			"token":        a.Pkcs11Token,
+			"key":          a.Pkcs11Key,
+			"error":        e,
+		}).Error("authority: Failed to load pkcs11 key")
+
+		errData = &errortypes.ErrorData{
+			Error:   "pkcs11_unavailable",
+			Message: "Failed to load key from PKCS#11 token",
+		}
+		return
+	}
+
+	pubKey, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "authority: Failed to parse public key"),
+		}
+		return
+	}
+
+	a.Info = &Info{
+		KeyAlg: keyAlg,
+	}
+	a.PrivateKey = ""
+	a.PublicKey = strings.TrimSpace(string(MarshalPublicKey(pubKey)))
+	a.RotateClear()
+
+	return
+}
+
+func (a *Authority) LoadRemote() (errData *errortypes.ErrorData, err error) {
+	a.RemoteUrl = strings.TrimSpace(a.RemoteUrl)
+	a.RemoteCa = strings.TrimSpace(a.RemoteCa)
+
+	if !strings.HasPrefix(a.RemoteUrl, "https://") &&
+		!strings.HasPrefix(a.RemoteUrl, "http://") {
+
+		errData = &errortypes.ErrorData{
+			Error:   "remote_url_invalid",
+			Message: "Remote signer URL must be an http or https URL",
+		}
+		return
+	}
+
+	if a.PrivateKey != "" {
+		errData = &errortypes.ErrorData{
+			Error:   "remote_private_key",
+			Message: "Remote signer cannot replace a local private key",
+		}
+		return
+	}
+
+	if a.PublicKey != "" {
+		return
+	}
+
+	signer, pubKey, errData := a.loadRemoteKey()
+	if errData != nil {
+		return
+	}
+
+	a.Info = &Info{
+		KeyAlg: GetKeyAlg(signer.PublicKey()),
+	}
+	a.PublicKey = pubKey
+	a.RotateClear()
+
+	return
+}
+
+func (a *Authority) loadRemoteKey() (signer *RemoteSigner, pubKey string,
+	errData *errortypes.ErrorData) {
+
+	signer, e := NewRemoteSigner(a.RemoteUrl, a.RemoteToken, a.RemoteCa, "")
+	if e != nil {
+		errData = &errortypes.ErrorData{
+			Error:   "remote_ca_invalid",
+			Message: "Remote signer certificate authority is invalid",
+		}
+		return
+	}
+
+	pubKey, e = signer.loadPublicKey()
+	if e != nil {
+		logrus.WithFields(logrus.Fields{
+			"authority_id": a.Id.Hex(),
+			"url":          a.RemoteUrl,
+			"error":        e,
+		}).Error("authority: Failed to load remote public key")
+
+		errData = &errortypes.ErrorData{
+			Error:   "remote_unavailable",
+			Message: "Failed to load public key from remote signer",
+		}
+		return
+	}
+
+	return
+}
+
+func (a *Authority) VerifyRemote() (errData *errortypes.ErrorData) {
+	if a.PublicKey == "" {
+		return
+	}
+
+	signer, _, errData := a.loadRemoteKey()
+	if errData != nil {
+		return
+	}
+
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(a.PublicKey))
+	if err != nil || !bytes.Equal(
+		pubKey.Marshal(), signer.PublicKey().Marshal()) {
+
+		errData = &errortypes.ErrorData{
+			Error: "remote_key_changed",
+			Message: "Remote signer public key does not match the " +
+				"authority public key",
+		}
+		return
+	}
+
+	return
+}
+
+func (a *Authority) RotateStage(keyAlg string, timestamp time.Time) (
+	err error) {
+
+	if a.RotateState != "" {
+		err = &errortypes.WriteError{
+			errors.New("authority: Key rotation already in progress"),
+		}
+		return
+	}
+
+	nextAuthr := &Authority{}
+	err = nextAuthr.GeneratePrivateKey(keyAlg)
+	if err != nil {
+		return
+	}
+
+	a.RotateState = RotateStaged
+	a.RotateTimestamp = timestamp
+	a.NextInfo = nextAuthr.Info
+	a.NextPrivateKey = nextAuthr.PrivateKey
+	a.NextPublicKey = nextAuthr.PublicKey
+
+	return
+}
+
+func (a *Authority) RotateSwitch() (err error) {
+	if a.RotateState != RotateStaged || a.NextPrivateKey == "" {
+		err = &errortypes.WriteError{
+			errors.New("authority: No staged key to rotate"),
+		}
+		return
+	}
+
+	a.RotateState = RotateSwitched
+	a.RotateTimestamp = time.Time{}
+	a.PreviousPublicKey = a.PublicKey
+	a.Info = a.NextInfo
+	a.PrivateKey = a.NextPrivateKey
+	a.PublicKey = a.NextPublicKey
+	a.NextInfo = nil
+	a.NextPrivateKey = ""
+	a.NextPublicKey = ""
+
+	return
+}
+
+func (a *Authority) RotateClear() {
+	a.RotateState = ""
+	a.RotateTimestamp = time.Time{}
+	a.NextInfo = nil
+	a.NextPrivateKey = ""
+	a.NextPublicKey = ""
+	a.PreviousPublicKey = ""
+}
+
+func (a *Authority) RotateReady() bool {
+	return a.RotateState == RotateStaged && !a.RotateTimestamp.IsZero() &&
+		!time.Now().Before(a.RotateTimestamp)
+}
+
+func (a *Authority) UserHasAccess(usr *user.User) bool {
+	if !a.MatchRoles {
+		return true
+	}
+	return usr.RolesMatch(a.Roles)
+}
+
+func (a *Authority) HostPrincipalAllowed(principal string) bool {
+	principalIp := net.ParseIP(principal)
+
+	for _, allowed := range a.HostPrincipals {
+		if allowed == principal {
+			return true
+		}
+
+		if principalIp != nil {
+			_, network, err := net.ParseCIDR(allowed)
+			if err == nil && network.Contains(principalIp) {
+				return true
+			}
+		} else if strings.HasPrefix(allowed, "*.") &&
+			strings.HasSuffix(principal, allowed[1:]) &&
+			len(principal) > len(allowed)-1 {
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a *Authority) GetHostPrincipals(tokn *HostToken, hostname string,
+	port int, pubKey, clientIp string, extraPrincipals []string) (
+	principals []string, reasons []string) {
+
+	domain := a.GetDomain(hostname)
+	principals = []string{domain}
+	principalsSet := set.NewSet(domain)
+	reasons = []string{}
+
+	for _, principal := range extraPrincipals {
+		principal = strings.TrimSpace(principal)
+		if principal == "" || principalsSet.Contains(principal) {
+			continue
+		}
+
+		if net.ParseIP(principal) == nil &&
+			!hostPrincipalRe.MatchString(principal) {
+
+			reasons = append(reasons, fmt.Sprintf(
+				"%s: Invalid principal", principal))
+			continue
+		}
+
+		if !tokn.Allowed(principal, clientIp) {
+			reasons = append(reasons, fmt.Sprintf(
+				"%s: Token is not allowed for principal", principal))
+			continue
+		}
+
+		// Only a short name matching the validated hostname is exempt
+		shortName := principal == hostname &&
+			!strings.Contains(principal, ".")
+
+		if !shortName && !a.HostPrincipalAllowed(principal) {
+			valid, principalReasons := a.DomainValidate(
+				principal, port, pubKey)
+			if !valid {
+				reasons = append(reasons, principalReasons...)
+				continue
+			}
+		}
+
+		principalsSet.Add(principal)
+		principals = append(principals, principal)
+	}
+
+	return
+}
+
+func (a *Authority) HostnameValidate(hostname string, port int,
+	pubKey string) (valid bool, reasons []string) {
+
+	valid, reasons = a.DomainValidate(a.GetDomain(hostname), port, pubKey)
+	return
+}
+
+func (a *Authority) DomainValidate(domain string, port int,
+	pubKey string) (valid bool, reasons []string) {
+
+	if ip := net.ParseIP(domain); ip != nil {
+		valid, reasons = a.challengeValidate(
+			domain, []net.IP{ip}, port, pubKey)
+		return
+	}
+
+	ips, err := net.LookupIP(domain)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "authority: Failed to lookup host"),
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"host":  domain,
+			"error": err,
+		}).Error("authority: Failed to lookup host")
+
+		reasons = []string{
+			fmt.Sprintf("%s: Failed to lookup host", domain),
+		}
+		return
+	}
+
+	if len(ips) == 0 {
+		logrus.WithFields(logrus.Fields{
+			"host": domain,
+		}).Error("authority: No addresses found for host")
+
+		reasons = []string{
+			fmt.Sprintf("%s: No addresses found for host", domain),
+		}
+		return
+	}
+
+	valid, reasons = a.challengeValidate(domain, ips, port, pubKey)
+	return
+}
+
+// Https clients verify the host domain so connections are not reused
+func (a *Authority) getChallengeClient(domain string) (
+	clnt *http.Client, err error) {
+
+	if !a.HostChallengeHttps {
+		clnt = client
+		return
+	}
+
+	tlsConf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if net.ParseIP(domain) == nil {
+		tlsConf.ServerName = domain
+	}
+
+	if a.HostChallengeCa != "" {
+		caPool := x509.NewCertPool()
+		if ok := caPool.AppendCertsFromPEM(
+			[]byte(a.HostChallengeCa)); !ok {
+
+			err = &errortypes.ParseError{
+				errors.New("authority: Failed to parse challenge certificate"),
+			}
+			return
+		}
+
+		tlsConf.RootCAs = caPool
+	}
+
+	clnt = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig:   tlsConf,
+			DisableKeepAlives: true,
+		},
+	}
+
+	return
+}
+
+func (a *Authority) challengeValidate(domain string, ips []net.IP,
+	port int, pubKey string) (valid bool, reasons []string) {
+
+	reasons = []string{}
+	if port == 0 {
+		port = 9748
+	}
+
+	scheme := "http"
+	if a.HostChallengeHttps {
+		scheme = "https"
+	}
+
+	clnt, err := a.getChallengeClient(domain)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"host":  domain,
+			"error": err,
+		}).Error("authority: Host validation failed")
+
+		reasons = append(reasons, fmt.Sprintf(
+			"%s: Invalid challenge certificate", domain))
+		return
+	}
+
+	for _, ip := range ips {
+		url := fmt.Sprintf("%s://%s/challenge", scheme,
+			net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+
+		reason := a.challengeRequest(clnt, url, pubKey)
+		if reason == "" {
+			valid = true
+			reasons = []string{}
+			return
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"host":   domain,
+			"url":    url,
+			"reason": reason,
+		}).Error("authority: Host validation failed")
+
+		reasons = append(reasons, fmt.Sprintf("%s: %s", url, reason))
+	}
+
+	return
+}
+
+func (a *Authority) challengeRequest(clnt *http.Client, url string,
+	pubKey string) (reason string) {
+
+	req, err := http.NewRequest(
+		"GET",
+		url,
+		nil,
+	)
+	if err != nil {
+		reason = "Failed to create request"
+		return
+	}
+
+	resp, err := clnt.Do(req)
+	if err != nil {
+		reason = fmt.Sprintf("Request failed %s", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		reason = fmt.Sprintf("Bad status %d", resp.StatusCode)
+		return
+	}
+
+	data := &validateData{}
+	err = json.NewDecoder(resp.Body).Decode(data)
+	if err != nil {
+		reason = "Failed to parse response"
+		return
+	}
+
+	hostPubKey := strings.TrimSpace(data.PublicKey)
+	if len(hostPubKey) > settings.System.SshPubKeyLen {
+		reason = "Public key too long"
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(pubKey),
+		[]byte(hostPubKey)) != 1 {
+
+		reason = "Public key does not match"
+		return
+	}
+
+	return
+}
+
+func (a *Authority) GetPrincipals(usr *user.User) (principals []string) {
+	if len(a.PrincipalMappings) == 0 {
+		principals = usr.Roles
+		return
+	}
+
+	principals = []string{}
+	principalsSet := set.NewSet()
+
+	roles := set.NewSet()
+	for _, role := range usr.Roles {
+		roles.Add(role)
+	}
+
+	add := func(principal string) {
+		principal = strings.TrimSpace(principal)
+		if principal == "" || principalInvalidRe.MatchString(principal) ||
+			principalsSet.Contains(principal) {
+
+			return
+		}
+		principalsSet.Add(principal)
+		principals = append(principals, principal)
+	}
+
+	for _, mapping := range a.PrincipalMappings {
+		mappingRoles := usr.Roles
+		if mapping.Role != "" {
+			if !roles.Contains(mapping.Role) {
+				continue
+			}
+			mappingRoles = []string{mapping.Role}
+		}
+
+		principal := RenderPrincipal(mapping.Principal, usr)
+
+		if strings.Contains(principal, PrincipalRole) {
+			for _, role := range mappingRoles {
+				add(strings.Replace(principal, PrincipalRole, role, -1))
+			}
+		} else {
+			add(principal)
+		}
+	}
 
 	return
 }
 
-func (a *Authority) GenerateEcPrivateKey() (err error) {
-	privKeyBytes, pubKeyBytes, err := GenerateEcKey()
-	if err != nil {
-		return
+func (a *Authority) GetSourceAddress(usr *user.User, clientIp string) (
+	addrs []string) {
+
+	addrs = []string{}
+	addrsSet := set.NewSet()
+
+	add := func(addr string) {
+		if addr != "" && !addrsSet.Contains(addr) {
+			addrsSet.Add(addr)
+			addrs = append(addrs, addr)
+		}
 	}
 
-	a.Info = &Info{
-		KeyAlg: "EC P384",
+	for _, addr := range a.SourceAddress {
+		add(addr)
 	}
-	a.PrivateKey = strings.TrimSpace(string(privKeyBytes))
-	a.PublicKey = strings.TrimSpace(string(pubKeyBytes))
 
-	return
-}
+	if a.RoleAddresses != nil {
+		roles := set.NewSet()
+		for _, role := range usr.Roles {
+			roles.Add(role)
+		}
 
-func (a *Authority) GetHostDomain() string {
-	if a.HostDomain == "" {
-		return ""
-	}
+		for _, roleAddr := range a.RoleAddresses {
+			if !roles.Contains(roleAddr.Role) {
+				continue
+			}
 
-	domain := "*." + a.HostDomain
+			for _, addr := range roleAddr.Address {
+				add(addr)
+			}
+		}
+	}
 
-	if a.HostProxy != "" {
-		hostProxy := strings.SplitN(a.HostProxy, "@", 2)
-		domain += " !" + hostProxy[len(hostProxy)-1]
+	if a.SourceClientIp && net.ParseIP(clientIp) != nil {
+		add(clientIp)
 	}
 
-	return domain
+	return
 }
 
-func (a *Authority) GetCertAuthority() string {
-	if a.HostDomain == "" {
-		return ""
-	}
-	return fmt.Sprintf("@cert-authority *.%s %s", a.HostDomain, a.PublicKey)
-}
+func (a *Authority) GetCriticalOptions(usr *user.User, clientIp string) (
+	options map[string]string) {
 
-func (a *Authority) UserHasAccess(usr *user.User) bool {
-	if !a.MatchRoles {
-		return true
+	options = map[string]string{}
+
+	if a.ForceCommand != "" {
+		options["force-command"] = a.ForceCommand
 	}
-	return usr.RolesMatch(a.Roles)
-}
 
-func (a *Authority) HostnameValidate(hostname string, port int,
-	pubKey string) bool {
+	addrs := a.GetSourceAddress(usr, clientIp)
+	if len(addrs) > 0 {
+		options["source-address"] = strings.Join(addrs, ",")
+	}
 
-	domain := a.GetDomain(hostname)
+	return
+}
 
-	ipsNet, err := net.LookupIP(domain)
-	if err != nil {
-		err = &errortypes.RequestError{
-			errors.Wrap(err, "authority: Failed to lookup host"),
-		}
+func (a *Authority) NextSerial(db *database.Database) (
+	serial uint64, err error) {
 
-		logrus.WithFields(logrus.Fields{
-			"error": err,
-		}).Error("authority: Failed to lookup host")
+	coll := db.Authorities()
 
-		return false
+	change := mgo.Change{
+		Update: &bson.M{
+			"$inc": &bson.M{
+				"serial_counter": 1,
+			},
+		},
+		ReturnNew: true,
 	}
 
-	ips := []net.IP{}
-	for _, ip := range ipsNet {
-		if ip.To4() != nil {
-			ips = append(ips, ip)
-		}
-	}
+	data := &serialData{}
 
-	if len(ips) == 0 {
-		logrus.WithFields(logrus.Fields{
-			"host": domain,
-		}).Error("authority: No IPv4 addresses found for host")
-		return false
+	_, err = coll.Find(&bson.M{
+		"_id": a.Id,
+	}).Apply(change, data)
+	if err != nil {
+		err = database.ParseError(err)
+		return
 	}
 
-	valid := false
-	url := ""
-	if port == 0 {
-		port = 9748
-	}
+	serial = uint64(data.SerialCounter)
 
-	for _, ip := range ips {
-		url = fmt.Sprintf("http://%s:%d/challenge", ip, port)
-		req, e := http.NewRequest(
-			"GET",
-			url,
-			nil,
-		)
-		if e != nil {
-			err = &errortypes.RequestError{
-				errors.Wrap(e, "authority: Validation request failed"),
-			}
-			continue
-		}
+	return
+}
 
-		resp, e := client.Do(req)
+func (a *Authority) GetSigner() (signer ssh.Signer, err error) {
+	switch a.Type {
+	case Pkcs11:
+		key, _, e := NewPkcs11Key(
+			a.Pkcs11Module, a.Pkcs11Token, a.Pkcs11Pin, a.Pkcs11Key)
 		if e != nil {
-			err = &errortypes.RequestError{
-				errors.Wrap(e, "authority: Validation request failed"),
-			}
-			continue
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != 200 {
-			err = &errortypes.RequestError{
-				errors.Newf("authority: Validation request bad status %d",
-					resp.StatusCode),
-			}
-			continue
+			err = e
+			return
 		}
 
-		data := &validateData{}
-		e = json.NewDecoder(resp.Body).Decode(data)
-		if e != nil {
+		signer, err = ssh.NewSignerFromSigner(key)
+		if err != nil {
 			err = &errortypes.ParseError{
-				errors.Wrap(e, "authority: Failed to parse response"),
-			}
-			break
-		}
-
-		hostPubKey := strings.TrimSpace(data.PublicKey)
-		if len(hostPubKey) > settings.System.SshPubKeyLen {
-			err = errortypes.ParseError{
-				errors.New("authority: Public key too long"),
+				errors.Wrap(err, "authority: Failed to create pkcs11 signer"),
 			}
-			break
+			return
 		}
 
-		if subtle.ConstantTimeCompare([]byte(pubKey),
-			[]byte(hostPubKey)) != 1 {
+		pubKey, _, _, _, e := ssh.ParseAuthorizedKey([]byte(a.PublicKey))
+		if e != nil || !bytes.Equal(
+			pubKey.Marshal(), signer.PublicKey().Marshal()) {
 
-			err = errortypes.AuthenticationError{
-				errors.New("authority: Public key does not match"),
+			signer = nil
+			err = &errortypes.AuthenticationError{
+				errors.New("authority: Pkcs11 key does not match " +
+					"authority public key"),
 			}
-			break
+			return
 		}
-
-		valid = true
-		err = nil
 		break
-	}
+	case Remote:
+		signer, err = NewRemoteSigner(
+			a.RemoteUrl, a.RemoteToken, a.RemoteCa, a.PublicKey)
+		if err != nil {
+			return
+		}
+		break
+	default:
+		privateKey, e := ParsePemKey(a.PrivateKey)
+		if e != nil {
+			err = e
+			return
+		}
 
-	if err != nil || !valid {
-		logrus.WithFields(logrus.Fields{
-			"host":  domain,
-			"url":   url,
-			"error": err,
-		}).Error("authority: Host validation failed")
-		return false
+		signer, err = ssh.NewSignerFromKey(privateKey)
+		if err != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(err, "authority: Failed to create signer"),
+			}
+			return
+		}
 	}
 
-	return true
+	return
 }
 
-func (a *Authority) CreateCertificate(usr *user.User, sshPubKey string) (
-	cert *ssh.Certificate, certMarshaled string, err error) {
-
-	privateKey, err := ParsePemKey(a.PrivateKey)
-	if err != nil {
-		return
-	}
+func (a *Authority) CreateCertificate(db *database.Database,
+	usr *user.User, sshPubKey string, extensions []string,
+	clientIp string, reqExpire int) (cert *ssh.Certificate,
+	certMarshaled string, err error) {
 
 	pubKey, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(sshPubKey))
 	if err != nil {
@@ -249,17 +929,22 @@ func (a *Authority) CreateCertificate(usr *user.User, sshPubKey string) (
 		return
 	}
 
-	serialHash := fnv.New64a()
-	serialHash.Write([]byte(bson.NewObjectId().Hex()))
-	serial := serialHash.Sum64()
+	serial, err := a.NextSerial(db)
+	if err != nil {
+		return
+	}
 
 	expire := a.Expire
 	if expire == 0 {
 		expire = 600
 	}
-	validAfter := time.Now().Add(-5 * time.Minute).Unix()
-	validBefore := time.Now().Add(
-		time.Duration(expire) * time.Minute).Unix()
+	if reqExpire > 0 && reqExpire < expire {
+		expire = reqExpire
+	}
+
+	now := time.Now()
+	validAfter := now.Add(-clockSkew).Unix()
+	validBefore := now.Add(time.Duration(expire) * time.Minute).Unix()
 
 	if len(usr.Roles) == 0 {
 		err = &errortypes.AuthenticationError{
@@ -268,26 +953,40 @@ func (a *Authority) CreateCertificate(usr *user.User, sshPubKey string) (
 		return
 	}
 
+	principals := a.GetPrincipals(usr)
+	if len(principals) == 0 {
+		err = &errortypes.AuthenticationError{
+			errors.New("authority: User has no principals"),
+		}
+		return
+	}
+
+	if a.SourceClientIp && net.ParseIP(clientIp) == nil {
+		err = &errortypes.AuthenticationError{
+			errors.New("authority: Client address unavailable"),
+		}
+		return
+	}
+
 	cert = &ssh.Certificate{
 		Key:             pubKey,
 		Serial:          serial,
 		CertType:        ssh.UserCert,
 		KeyId:           usr.Id.Hex(),
-		ValidPrincipals: usr.Roles,
+		ValidPrincipals: principals,
 		ValidAfter:      uint64(validAfter),
 		ValidBefore:     uint64(validBefore),
 		Permissions: ssh.Permissions{
-			Extensions: map[string]string{
-				"permit-X11-forwarding":   "",
-				"permit-agent-forwarding": "",
-				"permit-port-forwarding":  "",
-				"permit-pty":              "",
-				"permit-user-rc":          "",
-			},
+			CriticalOptions: a.GetCriticalOptions(usr, clientIp),
+			Extensions:      map[string]string{},
 		},
 	}
 
-	signer, err := ssh.NewSignerFromKey(privateKey)
+	for _, extension := range extensions {
+		cert.Permissions.Extensions[extension] = ""
+	}
+
+	signer, err := a.GetSigner()
 	if err != nil {
 		return
 	}
@@ -302,14 +1001,10 @@ func (a *Authority) CreateCertificate(usr *user.User, sshPubKey string) (
 	return
 }
 
-func (a *Authority) CreateHostCertificate(hostname string, sshPubKey string) (
+func (a *Authority) CreateHostCertificate(db *database.Database,
+	hostname string, principals []string, sshPubKey string) (
 	cert *ssh.Certificate, certMarshaled string, err error) {
 
-	privateKey, err := ParsePemKey(a.PrivateKey)
-	if err != nil {
-		return
-	}
-
 	pubKey, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(sshPubKey))
 	if err != nil {
 		err = &errortypes.ParseError{
@@ -318,29 +1013,30 @@ func (a *Authority) CreateHostCertificate(hostname string, sshPubKey string) (
 		return
 	}
 
-	serialHash := fnv.New64a()
-	serialHash.Write([]byte(bson.NewObjectId().Hex()))
-	serial := serialHash.Sum64()
+	serial, err := a.NextSerial(db)
+	if err != nil {
+		return
+	}
 
 	expire := a.HostExpire
 	if expire == 0 {
 		expire = 600
 	}
-	validAfter := time.Now().Add(-5 * time.Minute).Unix()
-	validBefore := time.Now().Add(
-		time.Duration(expire) * time.Minute).Unix()
+	now := time.Now()
+	validAfter := now.Add(-clockSkew).Unix()
+	validBefore := now.Add(time.Duration(expire) * time.Minute).Unix()
 
 	cert = &ssh.Certificate{
 		Key:             pubKey,
 		Serial:          serial,
 		CertType:        ssh.HostCert,
 		KeyId:           hostname,
-		ValidPrincipals: []string{a.GetDomain(hostname)},
+		ValidPrincipals: principals,
 		ValidAfter:      uint64(validAfter),
 		ValidBefore:     uint64(validBefore),
 	}
 
-	signer, err := ssh.NewSignerFromKey(privateKey)
+	signer, err := a.GetSigner()
 	if err != nil {
 		return
 	}
@@ -355,9 +1051,12 @@ func (a *Authority) CreateHostCertificate(hostname string, sshPubKey string) (
 	return
 }
 
-func (a *Authority) TokenNew() (err error) {
+func (a *Authority) TokenNew(name string, expires time.Time,
+	match string) (tokn *HostToken, errData *errortypes.ErrorData,
+	err error) {
+
 	if a.HostTokens == nil {
-		a.HostTokens = []string{}
+		a.HostTokens = []*HostToken{}
 	}
 
 	token, err := utils.RandStr(48)
@@ -365,18 +1064,33 @@ func (a *Authority) TokenNew() (err error) {
 		return
 	}
 
-	a.HostTokens = append(a.HostTokens, token)
+	tokn = &HostToken{
+		Id:        bson.NewObjectId(),
+		Token:     token,
+		Name:      name,
+		Timestamp: time.Now(),
+		Expires:   expires,
+		Match:     match,
+	}
+
+	errData = tokn.Validate()
+	if errData != nil {
+		tokn = nil
+		return
+	}
+
+	a.HostTokens = append(a.HostTokens, tokn)
 
 	return
 }
 
 func (a *Authority) TokenDelete(token string) (err error) {
 	if a.HostTokens == nil {
-		a.HostTokens = []string{}
+		a.HostTokens = []*HostToken{}
 	}
 
 	for i, tokn := range a.HostTokens {
-		if tokn == token {
+		if tokn.Token == token || tokn.Id.Hex() == token {
 			a.HostTokens = append(
 				a.HostTokens[:i], a.HostTokens[i+1:]...)
 			break
@@ -386,8 +1100,60 @@ func (a *Authority) TokenDelete(token string) (err error) {
 	return
 }
 
+func (a *Authority) GetToken(tokens []string) (tokn *HostToken) {
+	for _, token := range tokens {
+		for _, authrToken := range a.HostTokens {
+			if subtle.ConstantTimeCompare(
+				[]byte(token), []byte(authrToken.Token)) == 1 {
+
+				tokn = authrToken
+				return
+			}
+		}
+	}
+
+	return
+}
+
+func (a *Authority) TokenUsed(db *database.Database, tokn *HostToken) (
+	err error) {
+
+	coll := db.Authorities()
+
+	err = coll.Update(&bson.M{
+		"_id":            a.Id,
+		"host_tokens.id": tokn.Id,
+	}, &bson.M{
+		"$inc": &bson.M{
+			"host_tokens.$.count": 1,
+		},
+		"$set": &bson.M{
+			"host_tokens.$.last_used": time.Now(),
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
 func (a *Authority) Export(passphrase string) (encKey string, err error) {
+	if a.Type != Local {
+		err = &errortypes.ReadError{
+			errors.New("authority: Only local authorities can be exported"),
+		}
+		return
+	}
+
 	block, _ := pem.Decode([]byte(a.PrivateKey))
+	if block == nil {
+		err = &errortypes.ParseError{
+			errors.New("authority: Failed to decode private key"),
+		}
+		return
+	}
 
 	encBlock, err := x509.EncryptPEMBlock(
 		rand.Reader,
@@ -421,11 +1187,46 @@ func (a *Authority) Validate(db *database.Database) (
 		a.Roles = []string{}
 	}
 
-	if a.PrivateKey == "" {
-		err = a.GenerateRsaPrivateKey()
-		if err != nil {
+	if a.Type != Pkcs11 {
+		a.Pkcs11Module = ""
+		a.Pkcs11Token = ""
+		a.Pkcs11Key = ""
+		a.Pkcs11Pin = ""
+	}
+
+	if a.Type != Remote {
+		a.RemoteUrl = ""
+		a.RemoteToken = ""
+		a.RemoteCa = ""
+	}
+
+	switch a.Type {
+	case Local:
+		if a.PrivateKey == "" {
+			err = a.GenerateRsaPrivateKey()
+			if err != nil {
+				return
+			}
+		}
+		break
+	case Pkcs11:
+		errData, err = a.LoadPkcs11()
+		if err != nil || errData != nil {
+			return
+		}
+		break
+	case Remote:
+		errData, err = a.LoadRemote()
+		if err != nil || errData != nil {
 			return
 		}
+		break
+	default:
+		errData = &errortypes.ErrorData{
+			Error:   "type_invalid",
+			Message: "Authority type is invalid",
+		}
+		return
 	}
 
 	if a.Expire < 1 {
@@ -456,8 +1257,133 @@ func (a *Authority) Validate(db *database.Database) (
 		a.HostProxy = ""
 	}
 
+	a.HostChallengeCa = strings.TrimSpace(a.HostChallengeCa)
+	if !a.HostChallengeHttps {
+		a.HostChallengeCa = ""
+	} else if a.HostChallengeCa != "" {
+		caPool := x509.NewCertPool()
+		if ok := caPool.AppendCertsFromPEM(
+			[]byte(a.HostChallengeCa)); !ok {
+
+			errData = &errortypes.ErrorData{
+				Error:   "host_challenge_ca_invalid",
+				Message: "Host challenge certificate is invalid",
+			}
+			return
+		}
+	}
+
 	if a.HostTokens == nil || !a.HostCertificates {
-		a.HostTokens = []string{}
+		a.HostTokens = []*HostToken{}
+	}
+
+	hostPrincipals := []string{}
+	if a.HostCertificates {
+		for _, principal := range a.HostPrincipals {
+			principal = strings.TrimSpace(principal)
+			if principal == "" {
+				continue
+			}
+
+			if !ValidAddress(principal) &&
+				!hostPrincipalRe.MatchString(
+					strings.TrimPrefix(principal, "*.")) {
+
+				errData = &errortypes.ErrorData{
+					Error:   "host_principal_invalid",
+					Message: "Host principal must be a hostname or IP",
+				}
+				return
+			}
+
+			hostPrincipals = append(hostPrincipals, principal)
+		}
+	}
+	a.HostPrincipals = hostPrincipals
+
+	if a.Extensions == nil {
+		a.Extensions = DefaultExtensions
+	}
+
+	for _, extension := range a.Extensions {
+		if !extensions.Contains(extension) {
+			errData = &errortypes.ErrorData{
+				Error:   "extension_invalid",
+				Message: "Certificate extension is invalid",
+			}
+			return
+		}
+	}
+
+	a.ForceCommand = strings.TrimSpace(a.ForceCommand)
+
+	if a.SourceAddress == nil {
+		a.SourceAddress = []string{}
+	}
+
+	if a.PrincipalMappings == nil {
+		a.PrincipalMappings = []*PrincipalMapping{}
+	}
+
+	for _, mapping := range a.PrincipalMappings {
+		mapping.Role = strings.TrimSpace(mapping.Role)
+		mapping.Principal = strings.TrimSpace(mapping.Principal)
+
+		errData = ValidatePrincipal(mapping.Principal)
+		if errData != nil {
+			return
+		}
+	}
+
+	if a.RoleAddresses == nil {
+		a.RoleAddresses = []*RoleAddress{}
+	}
+
+	addrs := a.SourceAddress
+	for _, roleAddr := range a.RoleAddresses {
+		roleAddr.Role = strings.TrimSpace(roleAddr.Role)
+		if roleAddr.Role == "" {
+			errData = &errortypes.ErrorData{
+				Error:   "role_address_invalid",
+				Message: "Role source address must have a role",
+			}
+			return
+		}
+
+		if roleAddr.Address == nil {
+			roleAddr.Address = []string{}
+		}
+		addrs = append(addrs, roleAddr.Address...)
+	}
+
+	for _, addr := range addrs {
+		if !ValidAddress(addr) {
+			errData = &errortypes.ErrorData{
+				Error:   "source_address_invalid",
+				Message: "Source address must be an IP or CIDR",
+			}
+			return
+		}
+	}
+
+	switch a.RotateState {
+	case RotateStaged:
+		a.PreviousPublicKey = ""
+		if a.NextPrivateKey == "" {
+			a.RotateClear()
+		}
+		break
+	case RotateSwitched:
+		a.RotateTimestamp = time.Time{}
+		a.NextInfo = nil
+		a.NextPrivateKey = ""
+		a.NextPublicKey = ""
+		if a.PreviousPublicKey == "" {
+			a.RotateClear()
+		}
+		break
+	default:
+		a.RotateClear()
 	}
 
 	a.Format()
@@ -481,7 +1407,20 @@ func (a *Authority) Format() {
 
 	a.Roles = roles
 
-	sort.Strings(a.HostTokens)
+	sort.Slice(a.HostTokens, func(i, j int) bool {
+		return a.HostTokens[i].Timestamp.Before(a.HostTokens[j].Timestamp)
+	})
+
+	exts := []string{}
+	extsSet := set.NewSet()
+	for _, extension := range a.Extensions {
+		if !extsSet.Contains(extension) {
+			extsSet.Add(extension)
+			exts = append(exts, extension)
+		}
+	}
+	sort.Strings(exts)
+	a.Extensions = exts
 }
 
 func (a *Authority) Commit(db *database.Database) (err error) {
@@ -535,6 +1474,11 @@ func init() {
 		db := database.GetDatabase()
 		defer db.Close()
 
+		err = migrateTokens(db)
+		if err != nil {
+			return
+		}
+
 		authrs, err := GetAll(db)
 		if err != nil {
 			return
@@ -550,6 +1494,14 @@ func init() {
 					return
 				}
 			}
+
+			if authr.Extensions == nil {
+				authr.Extensions = DefaultExtensions
+				err = authr.CommitFields(db, set.NewSet("extensions"))
+				if err != nil {
+					return
+				}
+			}
 		}
 
 		return
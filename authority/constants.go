@@ -1,5 +1,79 @@
 package authority
 
+import (
+	"github.com/dropbox/godropbox/container/set"
+	"regexp"
+	"time"
+)
+
 const (
-	Local = "local"
+	Local  = "local"
+	Pkcs11 = "pkcs11"
+	Remote = "remote"
+
+	remoteRetries = 3
+	clockSkew     = 5 * time.Minute
+
+	Rsa4096 = "rsa_4096"
+	EcP384  = "ec_p384"
+	Ed25519 = "ed25519"
+
+	RotateStaged   = "staged"
+	RotateSwitched = "switched"
+
+	PermitX11Forwarding   = "permit-X11-forwarding"
+	PermitAgentForwarding = "permit-agent-forwarding"
+	PermitPortForwarding  = "permit-port-forwarding"
+	PermitPty             = "permit-pty"
+	PermitUserRc          = "permit-user-rc"
+	NoTouchRequired       = "no-touch-required"
+
+	PrincipalUsername      = "{{username}}"
+	PrincipalUsernameLocal = "{{username_local}}"
+	PrincipalUserId        = "{{user_id}}"
+	PrincipalRole          = "{{role}}"
+)
+
+var (
+	RotateFields = set.NewSet(
+		"info",
+		"private_key",
+		"public_key",
+		"rotate_state",
+		"rotate_timestamp",
+		"next_info",
+		"next_private_key",
+		"next_public_key",
+		"previous_public_key",
+	)
+	DefaultExtensions = []string{
+		PermitX11Forwarding,
+		PermitAgentForwarding,
+		PermitPortForwarding,
+		PermitPty,
+		PermitUserRc,
+	}
+	extensions = set.NewSet(
+		PermitX11Forwarding,
+		PermitAgentForwarding,
+		PermitPortForwarding,
+		PermitPty,
+		PermitUserRc,
+		NoTouchRequired,
+	)
+	principalTemplates = set.NewSet(
+		PrincipalUsername,
+		PrincipalUsernameLocal,
+		PrincipalUserId,
+		PrincipalRole,
+	)
+	keyAlgs = set.NewSet(
+		Rsa4096,
+		EcP384,
+		Ed25519,
+	)
+
+	principalTemplateRe = regexp.MustCompile(`{{[^}]*}}`)
+	principalInvalidRe  = regexp.MustCompile(`[\s,"'*?]`)
+	hostPrincipalRe     = regexp.MustCompile(`^[a-zA-Z0-9-_.]+$`)
 )
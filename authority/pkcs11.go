@@ -0,0 +1,395 @@
+package authority
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"fmt"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/miekg/pkcs11"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+var (
+	pkcs11Ctxs     = map[string]*pkcs11.Ctx{}
+	pkcs11CtxsLock = sync.Mutex{}
+	pkcs11Prefixes = map[crypto.Hash][]byte{
+		crypto.SHA1: {
+			0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02,
+			0x1a, 0x05, 0x00, 0x04, 0x14,
+		},
+		crypto.SHA256: {
+			0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01,
+			0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20,
+		},
+		crypto.SHA512: {
+			0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01,
+			0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40,
+		},
+	}
+	pkcs11Curves = map[string]elliptic.Curve{
+		"1.2.840.10045.3.1.7": elliptic.P256(),
+		"1.3.132.0.34":        elliptic.P384(),
+		"1.3.132.0.35":        elliptic.P521(),
+	}
+)
+
+type ecdsaSignature struct {
+	R *big.Int
+	S *big.Int
+}
+
+type Pkcs11Key struct {
+	module    string
+	token     string
+	pin       string
+	label     string
+	publicKey crypto.PublicKey
+}
+
+func (k *Pkcs11Key) Public() crypto.PublicKey {
+	return k.publicKey
+}
+
+func (k *Pkcs11Key) Sign(rand io.Reader, digest []byte,
+	opts crypto.SignerOpts) (sig []byte, err error) {
+
+	ctx, session, err := k.openSession()
+	if err != nil {
+		return
+	}
+	defer ctx.CloseSession(session)
+
+	privKey, err := k.findObject(ctx, session, pkcs11.CKO_PRIVATE_KEY, nil)
+	if err != nil {
+		return
+	}
+
+	var mechanism uint
+	var data []byte
+
+	switch k.publicKey.(type) {
+	case *rsa.PublicKey:
+		prefix, ok := pkcs11Prefixes[opts.HashFunc()]
+		if !ok {
+			err = &errortypes.ParseError{
+				errors.New("authority: Unsupported pkcs11 hash function"),
+			}
+			return
+		}
+
+		mechanism = pkcs11.CKM_RSA_PKCS
+		data = append(append([]byte{}, prefix...), digest...)
+		break
+	case *ecdsa.PublicKey:
+		mechanism = pkcs11.CKM_ECDSA
+		data = digest
+		break
+	default:
+		err = &errortypes.ParseError{
+			errors.New("authority: Unsupported pkcs11 key type"),
+		}
+		return
+	}
+
+	err = ctx.SignInit(
+		session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(mechanism, nil)},
+		privKey,
+	)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "authority: Failed to init pkcs11 sign"),
+		}
+		return
+	}
+
+	sig, err = ctx.Sign(session, data)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "authority: Failed to pkcs11 sign"),
+		}
+		return
+	}
+
+	if mechanism == pkcs11.CKM_ECDSA {
+		half := len(sig) / 2
+		sig, err = asn1.Marshal(ecdsaSignature{
+			R: new(big.Int).SetBytes(sig[:half]),
+			S: new(big.Int).SetBytes(sig[half:]),
+		})
+		if err != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(err, "authority: Failed to marshal ecdsa sig"),
+			}
+			return
+		}
+	}
+
+	return
+}
+
+func (k *Pkcs11Key) openSession() (ctx *pkcs11.Ctx,
+	session pkcs11.SessionHandle, err error) {
+
+	ctx, err = getPkcs11Ctx(k.module)
+	if err != nil {
+		return
+	}
+
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "authority: Failed to get pkcs11 slots"),
+		}
+		return
+	}
+
+	found := false
+	var slot uint
+	for _, slt := range slots {
+		info, e := ctx.GetTokenInfo(slt)
+		if e != nil {
+			continue
+		}
+
+		if strings.TrimSpace(info.Label) == k.token {
+			slot = slt
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		err = &errortypes.NotFoundError{
+			errors.Newf("authority: Failed to find pkcs11 token '%s'",
+				k.token),
+		}
+		return
+	}
+
+	session, err = ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "authority: Failed to open pkcs11 session"),
+		}
+		return
+	}
+
+	err = ctx.Login(session, pkcs11.CKU_USER, k.pin)
+	if err != nil {
+		if e, ok := err.(pkcs11.Error); ok &&
+			e == pkcs11.CKR_USER_ALREADY_LOGGED_IN {
+
+			err = nil
+		} else {
+			ctx.CloseSession(session)
+			err = &errortypes.AuthenticationError{
+				errors.Wrap(err, "authority: Failed to login pkcs11 token"),
+			}
+			return
+		}
+	}
+
+	return
+}
+
+func (k *Pkcs11Key) findObject(ctx *pkcs11.Ctx,
+	session pkcs11.SessionHandle, class uint, attrs []*pkcs11.Attribute) (
+	obj pkcs11.ObjectHandle, err error) {
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, k.label),
+	}
+	template = append(template, attrs...)
+
+	err = ctx.FindObjectsInit(session, template)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "authority: Failed to find pkcs11 key"),
+		}
+		return
+	}
+
+	objs, _, err := ctx.FindObjects(session, 1)
+	ctx.FindObjectsFinal(session)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "authority: Failed to find pkcs11 key"),
+		}
+		return
+	}
+
+	if len(objs) == 0 {
+		err = &errortypes.NotFoundError{
+			errors.Newf("authority: Failed to find pkcs11 key '%s'",
+				k.label),
+		}
+		return
+	}
+
+	obj = objs[0]
+
+	return
+}
+
+func (k *Pkcs11Key) loadPublicKey() (keyAlg string, err error) {
+	ctx, session, err := k.openSession()
+	if err != nil {
+		return
+	}
+	defer ctx.CloseSession(session)
+
+	obj, err := k.findObject(ctx, session, pkcs11.CKO_PUBLIC_KEY,
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		})
+	if err == nil {
+		attrs, e := ctx.GetAttributeValue(session, obj, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+		})
+		if e != nil {
+			err = &errortypes.ReadError{
+				errors.Wrap(e, "authority: Failed to read pkcs11 rsa key"),
+			}
+			return
+		}
+
+		publicKey := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(attrs[0].Value),
+			E: int(new(big.Int).SetBytes(attrs[1].Value).Int64()),
+		}
+
+		k.publicKey = publicKey
+		keyAlg = fmt.Sprintf("RSA %d", publicKey.N.BitLen())
+
+		return
+	}
+
+	obj, err = k.findObject(ctx, session, pkcs11.CKO_PUBLIC_KEY,
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		})
+	if err != nil {
+		return
+	}
+
+	attrs, err := ctx.GetAttributeValue(session, obj, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "authority: Failed to read pkcs11 ec key"),
+		}
+		return
+	}
+
+	oid := asn1.ObjectIdentifier{}
+	_, err = asn1.Unmarshal(attrs[0].Value, &oid)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "authority: Failed to parse pkcs11 ec params"),
+		}
+		return
+	}
+
+	curve, ok := pkcs11Curves[oid.String()]
+	if !ok {
+		err = &errortypes.ParseError{
+			errors.Newf("authority: Unsupported pkcs11 curve '%s'", oid),
+		}
+		return
+	}
+
+	// Point is normally wrapped in a DER octet string
+	point := attrs[1].Value
+	wrapped := []byte{}
+	rest, e := asn1.Unmarshal(point, &wrapped)
+	if e == nil && len(rest) == 0 {
+		point = wrapped
+	}
+
+	x, y := elliptic.Unmarshal(curve, point)
+	if x == nil {
+		err = &errortypes.ParseError{
+			errors.New("authority: Failed to parse pkcs11 ec point"),
+		}
+		return
+	}
+
+	k.publicKey = &ecdsa.PublicKey{
+		Curve: curve,
+		X:     x,
+		Y:     y,
+	}
+	keyAlg = "EC " + strings.Replace(curve.Params().Name, "-", "", 1)
+
+	return
+}
+
+func getPkcs11Ctx(module string) (ctx *pkcs11.Ctx, err error) {
+	pkcs11CtxsLock.Lock()
+	defer pkcs11CtxsLock.Unlock()
+
+	ctx = pkcs11Ctxs[module]
+	if ctx != nil {
+		return
+	}
+
+	ctx = pkcs11.New(module)
+	if ctx == nil {
+		err = &errortypes.ReadError{
+			errors.Newf("authority: Failed to load pkcs11 module '%s'",
+				module),
+		}
+		return
+	}
+
+	err = ctx.Initialize()
+	if err != nil {
+		if e, ok := err.(pkcs11.Error); ok &&
+			e == pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED {
+
+			err = nil
+		} else {
+			ctx.Destroy()
+			ctx = nil
+			err = &errortypes.ReadError{
+				errors.Wrap(err, "authority: Failed to init pkcs11 module"),
+			}
+			return
+		}
+	}
+
+	pkcs11Ctxs[module] = ctx
+
+	return
+}
+
+func NewPkcs11Key(module, token, pin, label string) (
+	key *Pkcs11Key, keyAlg string, err error) {
+
+	key = &Pkcs11Key{
+		module: module,
+		token:  token,
+		pin:    pin,
+		label:  label,
+	}
+
+	keyAlg, err = key.loadPublicKey()
+	if err != nil {
+		key = nil
+		return
+	}
+
+	return
+}
@@ -0,0 +1,226 @@
+package authority
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/settings"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type remotePublicKeyData struct {
+	PublicKey string `json:"public_key"`
+}
+
+type remoteSignData struct {
+	Algorithm string `json:"algorithm,omitempty"`
+	Data      []byte `json:"data"`
+}
+
+type remoteSignatureData struct {
+	Format string `json:"format"`
+	Blob   []byte `json:"blob"`
+}
+
+type RemoteSigner struct {
+	url       string
+	token     string
+	client    *http.Client
+	publicKey ssh.PublicKey
+}
+
+func (r *RemoteSigner) PublicKey() ssh.PublicKey {
+	return r.publicKey
+}
+
+func (r *RemoteSigner) Sign(rand io.Reader, data []byte) (
+	sig *ssh.Signature, err error) {
+
+	sig, err = r.SignWithAlgorithm(rand, data, "")
+	return
+}
+
+func (r *RemoteSigner) SignWithAlgorithm(rand io.Reader, data []byte,
+	algorithm string) (sig *ssh.Signature, err error) {
+
+	reqData, err := json.Marshal(&remoteSignData{
+		Algorithm: algorithm,
+		Data:      data,
+	})
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "authority: Failed to marshal sign request"),
+		}
+		return
+	}
+
+	respData := &remoteSignatureData{}
+	err = r.request("POST", "/sign", reqData, respData)
+	if err != nil {
+		return
+	}
+
+	sig = &ssh.Signature{
+		Format: respData.Format,
+		Blob:   respData.Blob,
+	}
+
+	err = r.publicKey.Verify(data, sig)
+	if err != nil {
+		sig = nil
+		err = &errortypes.AuthenticationError{
+			errors.Wrap(err, "authority: Remote signature is invalid"),
+		}
+		return
+	}
+
+	return
+}
+
+func (r *RemoteSigner) request(method, path string, body []byte,
+	respData interface{}) (err error) {
+
+	url := r.url + path
+
+	for i := 0; i < remoteRetries; i++ {
+		if i != 0 {
+			time.Sleep(1 * time.Second)
+		}
+
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(body)
+		}
+
+		req, e := http.NewRequest(method, url, reqBody)
+		if e != nil {
+			err = &errortypes.RequestError{
+				errors.Wrap(e, "authority: Remote signer request failed"),
+			}
+			return
+		}
+
+		if r.token != "" {
+			req.Header.Set("Authorization", "Bearer "+r.token)
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		resp, e := r.client.Do(req)
+		if e != nil {
+			err = &errortypes.RequestError{
+				errors.Wrap(e, "authority: Remote signer request failed"),
+			}
+			continue
+		}
+
+		if resp.StatusCode >= 500 {
+			resp.Body.Close()
+			err = &errortypes.RequestError{
+				errors.Newf("authority: Remote signer bad status %d",
+					resp.StatusCode),
+			}
+			continue
+		}
+
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			err = &errortypes.RequestError{
+				errors.Newf("authority: Remote signer bad status %d",
+					resp.StatusCode),
+			}
+			return
+		}
+
+		e = json.NewDecoder(resp.Body).Decode(respData)
+		resp.Body.Close()
+		if e != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(e, "authority: Failed to parse remote response"),
+			}
+			return
+		}
+
+		err = nil
+		return
+	}
+
+	return
+}
+
+func (r *RemoteSigner) loadPublicKey() (pubKey string, err error) {
+	respData := &remotePublicKeyData{}
+	err = r.request("GET", "/public_key", nil, respData)
+	if err != nil {
+		return
+	}
+
+	pubKey = strings.TrimSpace(respData.PublicKey)
+	if len(pubKey) > settings.System.SshPubKeyLen {
+		err = &errortypes.ParseError{
+			errors.New("authority: Public key too long"),
+		}
+		return
+	}
+
+	r.publicKey, _, _, _, err = ssh.ParseAuthorizedKey([]byte(pubKey))
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "authority: Failed to parse remote public key"),
+		}
+		return
+	}
+
+	return
+}
+
+func NewRemoteSigner(url, token, caCert, pubKey string) (
+	signer *RemoteSigner, err error) {
+
+	tlsConf := &tls.Config{}
+
+	if caCert != "" {
+		caPool := x509.NewCertPool()
+		if ok := caPool.AppendCertsFromPEM([]byte(caCert)); !ok {
+			err = &errortypes.ParseError{
+				errors.New("authority: Failed to parse remote certificate"),
+			}
+			return
+		}
+
+		tlsConf.RootCAs = caPool
+	}
+
+	signer = &RemoteSigner{
+		url:   strings.TrimRight(url, "/"),
+		token: token,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConf,
+			},
+		},
+	}
+
+	if pubKey != "" {
+		signer.publicKey, _, _, _, err = ssh.ParseAuthorizedKey(
+			[]byte(pubKey))
+		if err != nil {
+			signer = nil
+			err = &errortypes.ParseError{
+				errors.Wrap(err, "authority: Failed to parse public key"),
+			}
+			return
+		}
+	}
+
+	return
+}
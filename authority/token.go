@@ -0,0 +1,116 @@
+package authority
+
+import (
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"gopkg.in/mgo.v2/bson"
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+type legacyTokenData struct {
+	Id         bson.ObjectId `bson:"_id"`
+	HostTokens []string      `bson:"host_tokens"`
+}
+
+type HostToken struct {
+	Id        bson.ObjectId `bson:"id" json:"id"`
+	Token     string        `bson:"token" json:"token"`
+	Name      string        `bson:"name" json:"name"`
+	Timestamp time.Time     `bson:"timestamp" json:"timestamp"`
+	Expires   time.Time     `bson:"expires" json:"expires"`
+	Match     string        `bson:"match" json:"match"`
+	Count     int           `bson:"count" json:"count"`
+	LastUsed  time.Time     `bson:"last_used" json:"last_used"`
+}
+
+func (t *HostToken) Expired() bool {
+	return !t.Expires.IsZero() && time.Now().After(t.Expires)
+}
+
+func (t *HostToken) Allowed(hostname, clientIp string) bool {
+	if t.Match == "" {
+		return true
+	}
+
+	_, network, err := net.ParseCIDR(t.Match)
+	if err == nil {
+		ip := net.ParseIP(clientIp)
+		return ip != nil && network.Contains(ip)
+	}
+
+	matched, err := path.Match(t.Match, hostname)
+	if err != nil {
+		return false
+	}
+
+	return matched
+}
+
+func (t *HostToken) Validate() (errData *errortypes.ErrorData) {
+	t.Name = strings.TrimSpace(t.Name)
+	t.Match = strings.TrimSpace(t.Match)
+
+	if t.Match != "" {
+		_, _, err := net.ParseCIDR(t.Match)
+		if err != nil {
+			_, err = path.Match(t.Match, "")
+			if err != nil || strings.ContainsAny(t.Match, "/ ") {
+				errData = &errortypes.ErrorData{
+					Error:   "token_match_invalid",
+					Message: "Token match must be a hostname pattern or CIDR",
+				}
+				return
+			}
+		}
+	}
+
+	return
+}
+
+func migrateTokens(db *database.Database) (err error) {
+	coll := db.Authorities()
+
+	cursor := coll.Find(&bson.M{
+		"host_tokens": &bson.M{
+			"$type": "string",
+		},
+	}).Select(&bson.M{
+		"host_tokens": 1,
+	}).Iter()
+
+	data := &legacyTokenData{}
+	for cursor.Next(data) {
+		tokens := []*HostToken{}
+		for _, token := range data.HostTokens {
+			tokens = append(tokens, &HostToken{
+				Id:        bson.NewObjectId(),
+				Token:     token,
+				Timestamp: time.Now(),
+			})
+		}
+
+		err = coll.UpdateId(data.Id, &bson.M{
+			"$set": &bson.M{
+				"host_tokens": tokens,
+			},
+		})
+		if err != nil {
+			err = database.ParseError(err)
+			cursor.Close()
+			return
+		}
+
+		data = &legacyTokenData{}
+	}
+
+	err = cursor.Close()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
@@ -10,11 +10,16 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"fmt"
 	"github.com/dropbox/godropbox/errors"
 	"github.com/hillrnate/pritunl-zero/database"
 	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/user"
+	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/ssh"
 	"gopkg.in/mgo.v2/bson"
+	"net"
+	"strings"
 )
 
 func MarshalCertificate(cert *ssh.Certificate, comment string) []byte {
@@ -105,8 +110,184 @@ func GenerateEcKey() (encodedPriv, encodedPub []byte, err error) {
 	return
 }
 
+func GenerateEdKey() (encodedPriv, encodedPub []byte, err error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "authority: Failed to generate ed25519 key"),
+		}
+		return
+	}
+
+	pubKey, err := ssh.NewPublicKey(privateKey.Public())
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "authority: Failed to parse ed25519 key"),
+		}
+		return
+	}
+
+	block := &pem.Block{
+		Type:  "ED25519 PRIVATE KEY",
+		Bytes: privateKey,
+	}
+
+	encodedPriv = pem.EncodeToMemory(block)
+	encodedPub = MarshalPublicKey(pubKey)
+
+	return
+}
+
+func KeyStrong(key crypto.PrivateKey) bool {
+	switch privateKey := key.(type) {
+	case *rsa.PrivateKey:
+		return privateKey.N.BitLen() >= 2048
+	case *ecdsa.PrivateKey:
+		return privateKey.Curve.Params().BitSize >= 384
+	case ed25519.PrivateKey, *ed25519.PrivateKey:
+		return true
+	}
+
+	return false
+}
+
+func EncodePrivateKey(key crypto.PrivateKey) (encodedPriv, encodedPub []byte,
+	keyAlg string, err error) {
+
+	var block *pem.Block
+	var publicKey crypto.PublicKey
+
+	switch privateKey := key.(type) {
+	case *rsa.PrivateKey:
+		block = &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+		}
+		publicKey = privateKey.Public()
+		keyAlg = fmt.Sprintf("RSA %d", privateKey.N.BitLen())
+		break
+	case *ecdsa.PrivateKey:
+		keyBytes, e := x509.MarshalECPrivateKey(privateKey)
+		if e != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(e, "authority: Failed to marshal ec key"),
+			}
+			return
+		}
+
+		block = &pem.Block{
+			Type:  "EC PRIVATE KEY",
+			Bytes: keyBytes,
+		}
+		publicKey = privateKey.Public()
+		keyAlg = "EC " + strings.Replace(
+			privateKey.Curve.Params().Name, "-", "", 1)
+		break
+	case ed25519.PrivateKey:
+		block = &pem.Block{
+			Type:  "ED25519 PRIVATE KEY",
+			Bytes: privateKey,
+		}
+		publicKey = privateKey.Public()
+		keyAlg = "ED25519"
+		break
+	case *ed25519.PrivateKey:
+		encodedPriv, encodedPub, keyAlg, err = EncodePrivateKey(*privateKey)
+		return
+	default:
+		err = &errortypes.ParseError{
+			errors.New("authority: Unsupported private key type"),
+		}
+		return
+	}
+
+	pubKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "authority: Failed to parse public key"),
+		}
+		return
+	}
+
+	encodedPriv = pem.EncodeToMemory(block)
+	encodedPub = MarshalPublicKey(pubKey)
+
+	return
+}
+
+func ImportKey(data, passphrase string) (key crypto.PrivateKey,
+	err error) {
+
+	data = strings.TrimSpace(data)
+
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		err = &errortypes.ParseError{
+			errors.New("authority: Failed to decode private key"),
+		}
+		return
+	}
+
+	if block.Type == "OPENSSH PRIVATE KEY" {
+		if passphrase != "" {
+			key, err = ssh.ParseRawPrivateKeyWithPassphrase(
+				[]byte(data), []byte(passphrase))
+		} else {
+			key, err = ssh.ParseRawPrivateKey([]byte(data))
+		}
+		if err != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(err, "authority: Failed to parse openssh key"),
+			}
+			return
+		}
+
+		return
+	}
+
+	if x509.IsEncryptedPEMBlock(block) {
+		blockBytes, e := x509.DecryptPEMBlock(block, []byte(passphrase))
+		if e != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(e, "authority: Failed to decrypt private key"),
+			}
+			return
+		}
+
+		block = &pem.Block{
+			Type:  block.Type,
+			Bytes: blockBytes,
+		}
+	}
+
+	if block.Type == "PRIVATE KEY" {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(err, "authority: Failed to parse pkcs8 key"),
+			}
+			return
+		}
+
+		return
+	}
+
+	key, err = ParsePemKey(string(pem.EncodeToMemory(block)))
+	if err != nil {
+		return
+	}
+
+	return
+}
+
 func ParsePemKey(data string) (key crypto.PrivateKey, err error) {
 	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		err = &errortypes.ParseError{
+			errors.New("authority: Failed to decode private key"),
+		}
+		return
+	}
 
 	switch block.Type {
 	case "RSA PRIVATE KEY":
@@ -127,6 +308,15 @@ func ParsePemKey(data string) (key crypto.PrivateKey, err error) {
 			return
 		}
 		break
+	case "ED25519 PRIVATE KEY":
+		if len(block.Bytes) != ed25519.PrivateKeySize {
+			err = &errortypes.ParseError{
+				errors.New("authority: Failed to parse ed25519 key"),
+			}
+			return
+		}
+		key = ed25519.PrivateKey(block.Bytes)
+		break
 	default:
 		err = &errortypes.ParseError{
 			errors.Newf("authority: Unknown key type '%s'", block.Type),
@@ -137,6 +327,80 @@ func ParsePemKey(data string) (key crypto.PrivateKey, err error) {
 	return
 }
 
+func ValidatePrincipal(principal string) (errData *errortypes.ErrorData) {
+	if principal == "" {
+		errData = &errortypes.ErrorData{
+			Error:   "principal_invalid",
+			Message: "Principal mapping cannot be empty",
+		}
+		return
+	}
+
+	for _, tmpl := range principalTemplateRe.FindAllString(principal, -1) {
+		if !principalTemplates.Contains(tmpl) {
+			errData = &errortypes.ErrorData{
+				Error:   "principal_template_invalid",
+				Message: fmt.Sprintf("Unknown principal template %s", tmpl),
+			}
+			return
+		}
+	}
+
+	if principalInvalidRe.MatchString(
+		principalTemplateRe.ReplaceAllString(principal, "")) {
+
+		errData = &errortypes.ErrorData{
+			Error:   "principal_invalid",
+			Message: "Principal mapping contains invalid characters",
+		}
+		return
+	}
+
+	return
+}
+
+func RenderPrincipal(principal string, usr *user.User) string {
+	usernameLocal := strings.SplitN(usr.Username, "@", 2)[0]
+
+	principal = strings.Replace(
+		principal, PrincipalUsernameLocal, usernameLocal, -1)
+	principal = strings.Replace(
+		principal, PrincipalUsername, usr.Username, -1)
+	principal = strings.Replace(
+		principal, PrincipalUserId, usr.Id.Hex(), -1)
+
+	return principal
+}
+
+func ValidAddress(addr string) bool {
+	if _, _, err := net.ParseCIDR(addr); err == nil {
+		return true
+	}
+	return net.ParseIP(addr) != nil
+}
+
+func GetKeyAlg(pubKey ssh.PublicKey) string {
+	cryptoKey, ok := pubKey.(ssh.CryptoPublicKey)
+	if !ok {
+		return strings.ToUpper(pubKey.Type())
+	}
+
+	switch key := cryptoKey.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "EC " + strings.Replace(key.Curve.Params().Name, "-", "", 1)
+	case ed25519.PublicKey:
+		return "ED25519"
+	default:
+		return strings.ToUpper(pubKey.Type())
+	}
+}
+
+func ValidKeyAlg(keyAlg string) bool {
+	return keyAlg == "" || keyAlgs.Contains(keyAlg)
+}
+
 func Get(db *database.Database, authrId bson.ObjectId) (
 	authr *Authority, err error) {
 
@@ -204,7 +468,7 @@ func GetTokens(db *database.Database, tokens []string) (
 	authrs = []*Authority{}
 
 	cursor := coll.Find(&bson.M{
-		"host_tokens": &bson.M{
+		"host_tokens.token": &bson.M{
 			"$in": tokens,
 		},
 	}).Iter()
@@ -0,0 +1,234 @@
+package bastion
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/authority"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/event"
+	"github.com/hillrnate/pritunl-zero/node"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Bastion struct {
+	nodeHash   []byte
+	failedHash []byte
+	retry      time.Time
+	backoff    time.Duration
+	listener   net.Listener
+	lock       sync.Mutex
+	stop       bool
+}
+
+func (b *Bastion) hashNode() []byte {
+	hash := md5.New()
+	io.WriteString(hash, node.Self.Type)
+	io.WriteString(hash, strconv.Itoa(node.Self.BastionPort))
+
+	for _, authrId := range node.Self.BastionAuthorities {
+		io.WriteString(hash, authrId.Hex())
+	}
+
+	return hash.Sum(nil)
+}
+
+func (b *Bastion) loadHostKey(db *database.Database) (
+	signer ssh.Signer, err error) {
+
+	nde, err := node.Get(db, node.Self.Id)
+	if err != nil {
+		return
+	}
+
+	if nde.BastionHostKey == "" {
+		privKey, pubKey, e := authority.GenerateEdKey()
+		if e != nil {
+			err = e
+			return
+		}
+
+		nde.BastionHostKey = strings.TrimSpace(string(privKey))
+		nde.BastionPublicKey = strings.TrimSpace(string(pubKey))
+
+		err = nde.CommitFields(db, set.NewSet(
+			"bastion_host_key", "bastion_public_key"))
+		if err != nil {
+			return
+		}
+
+		event.PublishDispatch(db, "node.change")
+	}
+
+	privateKey, err := authority.ParsePemKey(nde.BastionHostKey)
+	if err != nil {
+		return
+	}
+
+	signer, err = ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "bastion: Failed to create host key signer"),
+		}
+		return
+	}
+
+	return
+}
+
+func (b *Bastion) start() (err error) {
+	db := database.GetDatabase()
+	defer db.Close()
+
+	port := node.Self.BastionPort
+	if port == 0 {
+		port = node.DefaultBastionPort
+	}
+	authrIds := node.Self.BastionAuthorities
+
+	signer, err := b.loadHostKey(db)
+	if err != nil {
+		return
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (
+			*ssh.Permissions, error) {
+
+			return authenticate(authrIds, conn, key)
+		},
+		ServerVersion: "SSH-2.0-pritunl-zero",
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		err = &errortypes.UnknownError{
+			errors.Wrap(err, "bastion: Server listen failed"),
+		}
+		return
+	}
+	b.listener = listener
+
+	logrus.WithFields(logrus.Fields{
+		"port":        port,
+		"authorities": len(authrIds),
+	}).Info("bastion: Starting bastion server")
+
+	go b.serve(listener, config)
+
+	return
+}
+
+func (b *Bastion) serve(listener net.Listener, config *ssh.ServerConfig) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			b.lock.Lock()
+			closed := b.listener != listener
+			b.lock.Unlock()
+
+			if closed {
+				return
+			}
+
+			err = &errortypes.UnknownError{
+				errors.Wrap(err, "bastion: Server accept failed"),
+			}
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Error("bastion: Bastion server error")
+
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		go handleConn(conn, config)
+	}
+}
+
+func (b *Bastion) restart() (err error) {
+	if b.listener != nil {
+		b.listener.Close()
+		b.listener = nil
+	}
+
+	if b.stop || !strings.Contains(node.Self.Type, node.Bastion) {
+		return
+	}
+
+	err = b.start()
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (b *Bastion) update(hash []byte) (stop bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.stop {
+		stop = true
+		return
+	}
+
+	if bytes.Compare(b.nodeHash, hash) == 0 {
+		return
+	}
+
+	failed := bytes.Compare(b.failedHash, hash) == 0
+	if failed && time.Now().Before(b.retry) {
+		return
+	}
+
+	err := b.restart()
+	if err != nil {
+		if !failed {
+			b.backoff = minRetry
+		} else if b.backoff < maxRetry/2 {
+			b.backoff *= 2
+		} else {
+			b.backoff = maxRetry
+		}
+		b.failedHash = hash
+		b.retry = time.Now().Add(b.backoff)
+
+		logrus.WithFields(logrus.Fields{
+			"retry": b.backoff.String(),
+			"error": err,
+		}).Error("bastion: Failed to start bastion server")
+		return
+	}
+
+	b.nodeHash = hash
+	b.failedHash = nil
+	b.backoff = 0
+
+	return
+}
+
+func (b *Bastion) Run() {
+	for !b.update(b.hashNode()) {
+		time.Sleep(1 * time.Second)
+	}
+}
+
+func (b *Bastion) Shutdown() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.stop = true
+	b.restart()
+}
@@ -0,0 +1,558 @@
+package bastion
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/audit"
+	"github.com/hillrnate/pritunl-zero/authority"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/host"
+	"github.com/hillrnate/pritunl-zero/node"
+	"github.com/hillrnate/pritunl-zero/policy"
+	"github.com/hillrnate/pritunl-zero/revocation"
+	"github.com/hillrnate/pritunl-zero/user"
+	"github.com/hillrnate/pritunl-zero/utils"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type directTcpipData struct {
+	Host       string
+	Port       uint32
+	OriginHost string
+	OriginPort uint32
+}
+
+type session struct {
+	conn      *ssh.ServerConn
+	cert      *ssh.Certificate
+	authrId   bson.ObjectId
+	userId    bson.ObjectId
+	principal string
+	target    string
+	remoteIp  string
+}
+
+type principalConn struct {
+	ssh.ConnMetadata
+	principal string
+}
+
+func (c *principalConn) User() string {
+	return c.principal
+}
+
+// Targets outside the authority domain are left in the principal to allow
+// principals such as email addresses
+func parseUser(authr *authority.Authority, username string) (
+	principal, target string) {
+
+	principal = username
+
+	i := strings.LastIndex(username, "@")
+	if i == -1 {
+		return
+	}
+
+	hostDomain := strings.ToLower(authr.HostDomain)
+	if hostDomain == "" {
+		return
+	}
+
+	hostname, _ := parseTarget(username[i+1:])
+	if !strings.HasSuffix(hostname, "."+hostDomain) {
+		return
+	}
+
+	principal = username[:i]
+	target = username[i+1:]
+
+	return
+}
+
+func authorityMatch(authr *authority.Authority, sigKey []byte) bool {
+	for _, pubKey := range authr.GetPublicKeys() {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pubKey))
+		if err != nil {
+			continue
+		}
+
+		if bytes.Equal(key.Marshal(), sigKey) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func verifySignature(cert *ssh.Certificate) bool {
+	if cert.Signature == nil {
+		return false
+	}
+
+	unsigned := *cert
+	unsigned.Signature = nil
+	data := unsigned.Marshal()
+
+	// Signed data excludes the empty signature length
+	err := cert.SignatureKey.Verify(data[:len(data)-4], cert.Signature)
+	if err != nil {
+		return false
+	}
+
+	return true
+}
+
+// Certificates with an invalid signature are only logged to prevent
+// forged audit entries
+func authenticateFailed(db *database.Database, authr *authority.Authority,
+	conn ssh.ConnMetadata, cert *ssh.Certificate, principal,
+	reason string) {
+
+	remoteIp := utils.StripPort(conn.RemoteAddr().String())
+
+	if !bson.IsObjectIdHex(cert.KeyId) || !verifySignature(cert) {
+		logrus.WithFields(logrus.Fields{
+			"remote_address": remoteIp,
+			"authority_id":   authr.Id.Hex(),
+			"reason":         reason,
+		}).Info("bastion: Unverified certificate failed to authenticate")
+		return
+	}
+
+	err := audit.NewRemote(db, remoteIp, bson.ObjectIdHex(cert.KeyId),
+		audit.BastionAuthFailed, audit.Fields{
+			"node_id":      node.Self.Id,
+			"authority_id": authr.Id,
+			"serial":       fmt.Sprintf("%d", cert.Serial),
+			"principal":    principal,
+			"reason":       reason,
+		})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("bastion: Failed to create audit entry")
+	}
+}
+
+func authenticate(authrIds []bson.ObjectId, conn ssh.ConnMetadata,
+	key ssh.PublicKey) (perms *ssh.Permissions, err error) {
+
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		err = &errortypes.AuthenticationError{
+			errors.New("bastion: Certificate required"),
+		}
+		return
+	}
+
+	db := database.GetDatabase()
+	defer db.Close()
+
+	authrs, err := authority.GetMulti(db, authrIds)
+	if err != nil {
+		return
+	}
+
+	sigKey := cert.SignatureKey.Marshal()
+
+	var authr *authority.Authority
+	for _, a := range authrs {
+		if authorityMatch(a, sigKey) {
+			authr = a
+			break
+		}
+	}
+
+	if authr == nil {
+		err = &errortypes.AuthenticationError{
+			errors.New("bastion: Certificate authority not permitted"),
+		}
+		return
+	}
+
+	principal, target := parseUser(authr, conn.User())
+	reason := ""
+
+	defer func() {
+		if reason != "" {
+			authenticateFailed(db, authr, conn, cert, principal, reason)
+		}
+	}()
+
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), sigKey)
+		},
+	}
+
+	_, err = checker.Authenticate(&principalConn{
+		ConnMetadata: conn,
+		principal:    principal,
+	}, cert)
+	if err != nil {
+		reason = "Certificate invalid, " + err.Error()
+		err = &errortypes.AuthenticationError{
+			errors.Wrap(err, "bastion: Certificate invalid"),
+		}
+		return
+	}
+
+	extension := "permit-port-forwarding"
+	if target != "" {
+		extension = "permit-pty"
+	}
+
+	if _, ok := cert.Permissions.Extensions[extension]; !ok {
+		reason = "Certificate does not permit " + extension
+		err = &errortypes.AuthenticationError{
+			errors.Newf("bastion: Certificate does not permit %s",
+				extension),
+		}
+		return
+	}
+
+	revoked, err := revocation.IsRevoked(db, authr.Id, cert)
+	if err != nil {
+		return
+	}
+
+	if revoked {
+		reason = "Certificate revoked"
+		err = &errortypes.AuthenticationError{
+			errors.New("bastion: Certificate revoked"),
+		}
+		return
+	}
+
+	if !bson.IsObjectIdHex(cert.KeyId) {
+		err = &errortypes.AuthenticationError{
+			errors.New("bastion: Certificate key id invalid"),
+		}
+		return
+	}
+
+	usr, err := user.Get(db, bson.ObjectIdHex(cert.KeyId))
+	if err != nil {
+		return
+	}
+
+	if usr.Disabled || !authr.UserHasAccess(usr) {
+		reason = "User not permitted"
+		err = &errortypes.AuthenticationError{
+			errors.New("bastion: User not permitted"),
+		}
+		return
+	}
+
+	perms = &ssh.Permissions{
+		CriticalOptions: cert.CriticalOptions,
+		Extensions: map[string]string{
+			"authority_id": authr.Id.Hex(),
+			"certificate":  string(cert.Marshal()),
+			"principal":    principal,
+			"target":       target,
+		},
+	}
+
+	return
+}
+
+func newSession(conn *ssh.ServerConn) (sess *session, err error) {
+	perms := conn.Permissions
+	if perms == nil || perms.Extensions == nil {
+		err = &errortypes.AuthenticationError{
+			errors.New("bastion: Connection missing permissions"),
+		}
+		return
+	}
+
+	key, err := ssh.ParsePublicKey([]byte(perms.Extensions["certificate"]))
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "bastion: Failed to parse certificate"),
+		}
+		return
+	}
+
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		err = &errortypes.ParseError{
+			errors.New("bastion: Connection key is not a certificate"),
+		}
+		return
+	}
+
+	sess = &session{
+		conn:      conn,
+		cert:      cert,
+		authrId:   bson.ObjectIdHex(perms.Extensions["authority_id"]),
+		userId:    bson.ObjectIdHex(cert.KeyId),
+		principal: perms.Extensions["principal"],
+		target:    perms.Extensions["target"],
+		remoteIp:  utils.StripPort(conn.RemoteAddr().String()),
+	}
+
+	return
+}
+
+func (s *session) authorize(db *database.Database, hostname string) (
+	reason string, err error) {
+
+	permitted := false
+	for _, authrId := range node.Self.BastionAuthorities {
+		if authrId == s.authrId {
+			permitted = true
+			break
+		}
+	}
+
+	if !permitted {
+		reason = "Authority not permitted on bastion"
+		return
+	}
+
+	if uint64(time.Now().Unix()) >= s.cert.ValidBefore {
+		reason = "Certificate has expired"
+		return
+	}
+
+	authr, err := authority.Get(db, s.authrId)
+	if err != nil {
+		return
+	}
+
+	revoked, err := revocation.IsRevoked(db, authr.Id, s.cert)
+	if err != nil {
+		return
+	}
+
+	if revoked {
+		reason = "Certificate has been revoked"
+		return
+	}
+
+	usr, err := user.Get(db, s.userId)
+	if err != nil {
+		return
+	}
+
+	if usr.Disabled {
+		reason = "User is disabled"
+		return
+	}
+
+	if !authr.UserHasAccess(usr) {
+		reason = "User roles do not match authority"
+		return
+	}
+
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	hostDomain := strings.ToLower(authr.HostDomain)
+
+	if hostDomain == "" || !strings.HasSuffix(hostname, "."+hostDomain) {
+		reason = "Host is not in authority domain"
+		return
+	}
+
+	blocked, err := host.IsBlocked(
+		db, authr.Id, strings.TrimSuffix(hostname, "."+hostDomain))
+	if err != nil {
+		return
+	}
+
+	if blocked {
+		reason = "Host is blocked"
+		return
+	}
+
+	policies, err := policy.GetAuthoritiesRoles(
+		db, []bson.ObjectId{authr.Id}, usr.Roles)
+	if err != nil {
+		return
+	}
+
+	if !policy.HostAllowed(policies, authr.Id, hostname) {
+		reason = "Host not permitted by policy"
+		return
+	}
+
+	return
+}
+
+func (s *session) audit(db *database.Database, typ string,
+	fields audit.Fields) {
+
+	fields["node_id"] = node.Self.Id
+	fields["authority_id"] = s.authrId
+	fields["serial"] = fmt.Sprintf("%d", s.cert.Serial)
+	fields["principal"] = s.principal
+
+	err := audit.NewRemote(db, s.remoteIp, s.userId, typ, fields)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("bastion: Failed to create audit entry")
+	}
+}
+
+func (s *session) connect(data *directTcpipData) (conn net.Conn,
+	reason ssh.RejectionReason, message string) {
+
+	db := database.GetDatabase()
+	defer db.Close()
+
+	fields := audit.Fields{
+		"host": data.Host,
+		"port": data.Port,
+	}
+
+	message, err := s.authorize(db, data.Host)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("bastion: Failed to authorize forward")
+		message = "Authorization failed"
+	}
+
+	if message == "" {
+		authr, e := authority.Get(db, s.authrId)
+		if e != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": e,
+			}).Error("bastion: Failed to authorize forward")
+			message = "Authorization failed"
+		} else if authr.RecordingRequired {
+			message = "Host requires a recorded terminal session"
+		}
+	}
+
+	if message != "" {
+		reason = ssh.Prohibited
+		fields["reason"] = message
+		s.audit(db, audit.BastionForwardDeny, fields)
+		return
+	}
+
+	conn, err = net.DialTimeout("tcp", net.JoinHostPort(
+		data.Host, strconv.Itoa(int(data.Port))), 10*time.Second)
+	if err != nil {
+		conn = nil
+		reason = ssh.ConnectionFailed
+		message = "Connection failed"
+		fields["reason"] = message
+		s.audit(db, audit.BastionForwardDeny, fields)
+		return
+	}
+
+	s.audit(db, audit.BastionForward, fields)
+
+	return
+}
+
+func (s *session) forward(newChan ssh.NewChannel) {
+	data := &directTcpipData{}
+
+	err := ssh.Unmarshal(newChan.ExtraData(), data)
+	if err != nil {
+		newChan.Reject(ssh.ConnectionFailed, "Invalid forward request")
+		return
+	}
+
+	conn, reason, message := s.connect(data)
+	if conn == nil {
+		newChan.Reject(reason, message)
+		return
+	}
+	defer conn.Close()
+
+	channel, reqs, err := newChan.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	go ssh.DiscardRequests(reqs)
+
+	waiter := sync.WaitGroup{}
+	waiter.Add(2)
+
+	go func() {
+		defer waiter.Done()
+		io.Copy(conn, channel)
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.CloseWrite()
+		}
+	}()
+
+	go func() {
+		defer waiter.Done()
+		io.Copy(channel, conn)
+		channel.CloseWrite()
+	}()
+
+	waiter.Wait()
+}
+
+func handleConn(netConn net.Conn, config *ssh.ServerConfig) {
+	defer netConn.Close()
+
+	netConn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	conn, chans, reqs, err := ssh.NewServerConn(netConn, config)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"remote_address": netConn.RemoteAddr().String(),
+			"error":          err,
+		}).Info("bastion: Connection failed to authenticate")
+		return
+	}
+	defer conn.Close()
+
+	netConn.SetDeadline(time.Time{})
+
+	go ssh.DiscardRequests(reqs)
+
+	sess, err := newSession(conn)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("bastion: Failed to load session")
+		return
+	}
+
+	for newChan := range chans {
+		switch newChan.ChannelType() {
+		case "direct-tcpip":
+			if sess.target != "" {
+				newChan.Reject(ssh.Prohibited,
+					"Port forwarding is not permitted with a session target")
+				break
+			}
+
+			go sess.forward(newChan)
+			break
+		case "session":
+			if sess.target == "" {
+				newChan.Reject(ssh.Prohibited,
+					"Only port forwarding is permitted")
+				break
+			}
+
+			go sess.relay(newChan)
+			break
+		default:
+			newChan.Reject(ssh.UnknownChannelType,
+				"Channel type not permitted")
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package bastion
+
+import (
+	"time"
+)
+
+const (
+	minRetry = 5 * time.Second
+	maxRetry = 10 * time.Minute
+)
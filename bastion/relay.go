@@ -0,0 +1,456 @@
+package bastion
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/audit"
+	"github.com/hillrnate/pritunl-zero/authority"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/node"
+	"github.com/hillrnate/pritunl-zero/recording"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var hostKeyAlgorithms = []string{
+	ssh.CertAlgoED25519v01,
+	ssh.CertAlgoECDSA256v01,
+	ssh.CertAlgoECDSA384v01,
+	ssh.CertAlgoECDSA521v01,
+	ssh.CertAlgoRSAv01,
+}
+
+type ptyRequestData struct {
+	Term    string
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+	Modes   string
+}
+
+type windowChangeData struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+type execData struct {
+	Command string
+}
+
+type exitStatusData struct {
+	Status uint32
+}
+
+type relay struct {
+	sess     *session
+	channel  ssh.Channel
+	agent    bool
+	pty      *ptyRequestData
+	requests []*ssh.Request
+	client   *ssh.Client
+	target   ssh.Channel
+	recorder *recording.Writer
+	copied   chan struct{}
+	denied   string
+}
+
+func parseTarget(target string) (hostname string, port int) {
+	hostname = target
+	port = 22
+
+	hst, prt, err := net.SplitHostPort(target)
+	if err == nil {
+		p, e := strconv.Atoi(prt)
+		if e == nil && p > 0 && p <= 65535 {
+			hostname = hst
+			port = p
+		}
+	}
+
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+
+	return
+}
+
+func (r *relay) deny() {
+	r.channel.Stderr().Write([]byte("bastion: " + r.denied + "\r\n"))
+	r.channel.SendRequest("exit-status", false,
+		ssh.Marshal(&exitStatusData{1}))
+	r.channel.Close()
+	r.denied = ""
+}
+
+// Agent channel must remain open until the signers are no longer needed
+func (r *relay) signers() (agentChan ssh.Channel, signers []ssh.Signer,
+	err error) {
+
+	agentChan, reqs, err := r.sess.conn.OpenChannel(
+		"auth-agent@openssh.com", nil)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "bastion: Failed to open agent channel"),
+		}
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	agentSigners, err := agent.NewClient(agentChan).Signers()
+	if err != nil {
+		agentChan.Close()
+		agentChan = nil
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "bastion: Failed to get agent keys"),
+		}
+		return
+	}
+
+	certKey := r.sess.cert.Marshal()
+	signers = []ssh.Signer{}
+
+	for _, signer := range agentSigners {
+		if bytes.Equal(signer.PublicKey().Marshal(), certKey) {
+			signers = append(signers, signer)
+		}
+	}
+
+	return
+}
+
+func (r *relay) connect(db *database.Database, hostname string,
+	port int) (client *ssh.Client, message string, err error) {
+
+	authr, err := authority.Get(db, r.sess.authrId)
+	if err != nil {
+		return
+	}
+
+	agentChan, signers, err := r.signers()
+	if err != nil {
+		return
+	}
+	defer agentChan.Close()
+
+	if len(signers) == 0 {
+		message = "Certificate not available in forwarded agent"
+		return
+	}
+
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			return authorityMatch(authr, auth.Marshal())
+		},
+	}
+
+	config := &ssh.ClientConfig{
+		User: r.sess.principal,
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signers...),
+		},
+		HostKeyCallback:   checker.CheckHostKey,
+		HostKeyAlgorithms: hostKeyAlgorithms,
+		Timeout:           10 * time.Second,
+	}
+
+	client, e := ssh.Dial("tcp", net.JoinHostPort(
+		hostname, strconv.Itoa(port)), config)
+	if e != nil {
+		client = nil
+		message = "Connection failed"
+
+		logrus.WithFields(logrus.Fields{
+			"host":  hostname,
+			"port":  port,
+			"error": e,
+		}).Info("bastion: Failed to connect to session target")
+		return
+	}
+
+	return
+}
+
+func (r *relay) start(req *ssh.Request) (ok bool) {
+	if r.client != nil {
+		return
+	}
+
+	hostname, port := parseTarget(r.sess.target)
+	command := ""
+
+	if req.Type == "exec" {
+		data := &execData{}
+		err := ssh.Unmarshal(req.Payload, data)
+		if err != nil {
+			return
+		}
+		command = data.Command
+	}
+
+	fields := audit.Fields{
+		"host": hostname,
+		"port": port,
+	}
+	if command != "" {
+		fields["command"] = command
+	}
+
+	db := database.GetDatabase()
+	defer db.Close()
+
+	message, err := r.sess.authorize(db, hostname)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("bastion: Failed to authorize session")
+		message = "Authorization failed"
+	}
+
+	if message == "" && r.pty == nil {
+		message = "Terminal required, sessions must request a pty"
+	}
+
+	if message == "" && !r.agent {
+		message = "Agent forwarding required to connect to target"
+	}
+
+	if message == "" {
+		r.client, message, err = r.connect(db, hostname, port)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Error("bastion: Failed to connect session")
+			message = "Connection failed"
+		}
+	}
+
+	var target ssh.Channel
+	var targetReqs <-chan *ssh.Request
+
+	if message == "" {
+		target, targetReqs, err = r.client.OpenChannel("session", nil)
+		if err != nil {
+			message = "Failed to open target session"
+		}
+	}
+
+	if message == "" {
+		r.recorder, err = recording.NewWriter(db, &recording.Recording{
+			NodeId:      node.Self.Id,
+			UserId:      r.sess.userId,
+			AuthorityId: r.sess.authrId,
+			Serial:      fmt.Sprintf("%d", r.sess.cert.Serial),
+			Principal:   r.sess.principal,
+			Host:        hostname,
+			Port:        port,
+			Command:     command,
+			RemoteIp:    r.sess.remoteIp,
+		}, int(r.pty.Columns), int(r.pty.Rows), r.pty.Term)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Error("bastion: Failed to create session recording")
+
+			target.Close()
+			message = "Recording unavailable"
+		}
+	}
+
+	if message != "" {
+		fields["reason"] = message
+		r.sess.audit(db, audit.BastionSessionDeny, fields)
+		r.denied = message
+		ok = true
+		return
+	}
+
+	for _, bufReq := range r.requests {
+		_, err = target.SendRequest(bufReq.Type, true, bufReq.Payload)
+		if err != nil {
+			target.Close()
+			return
+		}
+	}
+	r.requests = nil
+
+	ok, err = target.SendRequest(req.Type, true, req.Payload)
+	if err != nil || !ok {
+		ok = false
+		target.Close()
+		return
+	}
+
+	r.target = target
+
+	fields["recording_id"] = r.recorder.Recording.Id
+	r.sess.audit(db, audit.BastionSession, fields)
+
+	r.copied = make(chan struct{})
+	go r.copy(target, targetReqs)
+
+	return
+}
+
+func (r *relay) copy(target ssh.Channel, targetReqs <-chan *ssh.Request) {
+	defer close(r.copied)
+
+	input := make(chan struct{})
+	go func() {
+		defer close(input)
+		io.Copy(target, io.TeeReader(
+			r.channel, r.recorder.Stream(recording.Input)))
+		target.CloseWrite()
+	}()
+
+	waiter := sync.WaitGroup{}
+	waiter.Add(3)
+
+	go func() {
+		defer waiter.Done()
+		io.Copy(r.channel, io.TeeReader(
+			target, r.recorder.Stream(recording.Output)))
+	}()
+
+	go func() {
+		defer waiter.Done()
+		io.Copy(r.channel.Stderr(), io.TeeReader(
+			target.Stderr(), r.recorder.Stream(recording.Output)))
+	}()
+
+	go func() {
+		defer waiter.Done()
+		for req := range targetReqs {
+			ok, _ := r.channel.SendRequest(
+				req.Type, req.WantReply, req.Payload)
+			if req.WantReply {
+				req.Reply(ok, nil)
+			}
+		}
+	}()
+
+	waiter.Wait()
+
+	r.channel.Close()
+	<-input
+}
+
+func (r *relay) handle(req *ssh.Request) (ok bool) {
+	switch req.Type {
+	case "auth-agent-req@openssh.com":
+		r.agent = true
+		ok = true
+		break
+	case "pty-req":
+		if r.target != nil || r.pty != nil {
+			break
+		}
+
+		data := &ptyRequestData{}
+		err := ssh.Unmarshal(req.Payload, data)
+		if err != nil {
+			break
+		}
+
+		r.pty = data
+		r.requests = append(r.requests, req)
+		ok = true
+		break
+	case "env":
+		if r.target != nil {
+			break
+		}
+
+		r.requests = append(r.requests, req)
+		ok = true
+		break
+	case "window-change":
+		if r.target == nil {
+			break
+		}
+
+		data := &windowChangeData{}
+		err := ssh.Unmarshal(req.Payload, data)
+		if err != nil {
+			break
+		}
+
+		r.recorder.Resize(int(data.Columns), int(data.Rows))
+		ok, _ = r.target.SendRequest(req.Type, false, req.Payload)
+		break
+	case "signal":
+		if r.target == nil {
+			break
+		}
+
+		ok, _ = r.target.SendRequest(req.Type, false, req.Payload)
+		break
+	case "shell", "exec":
+		ok = r.start(req)
+		break
+	}
+
+	return
+}
+
+func (r *relay) close() {
+	if r.target != nil {
+		r.target.Close()
+	}
+
+	if r.client != nil {
+		r.client.Close()
+	}
+
+	// Recorder must remain open until the input copy ends
+	if r.copied != nil {
+		<-r.copied
+	}
+
+	if r.recorder != nil {
+		db := database.GetDatabase()
+		defer db.Close()
+
+		err := r.recorder.Close(db)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+			}).Error("bastion: Failed to close session recording")
+		}
+	}
+}
+
+func (s *session) relay(newChan ssh.NewChannel) {
+	channel, reqs, err := newChan.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	rly := &relay{
+		sess:     s,
+		channel:  channel,
+		requests: []*ssh.Request{},
+	}
+	defer rly.close()
+
+	for req := range reqs {
+		ok := rly.handle(req)
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+
+		if rly.denied != "" {
+			rly.deny()
+		}
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"github.com/hillrnate/pritunl-zero/database"
 	"github.com/hillrnate/pritunl-zero/errortypes"
 	"github.com/hillrnate/pritunl-zero/policy"
+	"github.com/hillrnate/pritunl-zero/revocation"
 	"github.com/hillrnate/pritunl-zero/settings"
 	"github.com/hillrnate/pritunl-zero/ssh"
+	"github.com/hillrnate/pritunl-zero/sshkey"
 	"github.com/hillrnate/pritunl-zero/user"
 	"github.com/hillrnate/pritunl-zero/utils"
 	"gopkg.in/mgo.v2/bson"
@@ -19,11 +21,14 @@ import (
 )
 
 type Challenge struct {
-	Id            string        `bson:"_id"`
-	CertificateId bson.ObjectId `bson:"certificate_id,omitempty"`
-	Timestamp     time.Time     `bson:"timestamp"`
-	State         string        `bson:"state"`
-	PubKey        string        `bson:"pub_key"`
+	Id            string                `bson:"_id"`
+	CertificateId bson.ObjectId         `bson:"certificate_id,omitempty"`
+	Timestamp     time.Time             `bson:"timestamp"`
+	State         string                `bson:"state"`
+	PubKey        string                `bson:"pub_key"`
+	Expire        int                   `bson:"expire"`
+	ClientIp      string                `bson:"client_ip,omitempty"`
+	ErrorData     *errortypes.ErrorData `bson:"error_data,omitempty"`
 }
 
 func (c *Challenge) Approve(db *database.Database, usr *user.User,
@@ -52,6 +57,16 @@ func (c *Challenge) Approve(db *database.Database, usr *user.User,
 	for _, polcy := range policies {
 		errData, err = polcy.ValidateUser(db, usr, r)
 		if err != nil || errData != nil {
+			if usr.Disabled {
+				err = revocation.RevokeUser(
+					db, usr.Id, "User disabled by policy")
+				if err != nil {
+					return
+				}
+			}
+
+			c.ErrorData = errData
+
 			err = c.Deny(db, usr)
 			if err != nil {
 				return
@@ -62,16 +77,48 @@ func (c *Challenge) Approve(db *database.Database, usr *user.User,
 
 	keybaseMode := policy.KeybaseMode(policies)
 	if keybaseMode == policy.Required {
+		errData = &errortypes.ErrorData{
+			Error:   "keybase_required",
+			Message: "Keybase is required for this user",
+		}
+		c.ErrorData = errData
+
 		err = c.Deny(db, usr)
 		if err != nil {
 			return
 		}
+		return
+	}
+
+	registeredOnly := len(authrs) > 0
+	for _, authr := range authrs {
+		if !authr.RegisteredKeys {
+			registeredOnly = false
+			break
+		}
+	}
 
-		errData = &errortypes.ErrorData{
-			Error:   "keybase_required",
-			Message: "Keybase is required for this user",
+	if registeredOnly {
+		registered, e := sshkey.IsRegistered(
+			db, usr.Id, ssh.Fingerprint(c.PubKey))
+		if e != nil {
+			err = e
+			return
+		}
+
+		if !registered {
+			errData = &errortypes.ErrorData{
+				Error:   "ssh_key_unregistered",
+				Message: "SSH key is not registered",
+			}
+			c.ErrorData = errData
+
+			err = c.Deny(db, usr)
+			if err != nil {
+				return
+			}
+			return
 		}
-		return
 	}
 
 	for _, polcy := range policies {
@@ -92,16 +139,34 @@ func (c *Challenge) Approve(db *database.Database, usr *user.User,
 		return
 	}
 
-	agnt, err := agent.Parse(db, r)
+	// Challenges started by a device use the device address instead of
+	// the address of the approving browser
+	var agnt *agent.Agent
+	if c.ClientIp != "" {
+		agnt, err = agent.ParseIp(db, c.ClientIp)
+	} else {
+		agnt, err = agent.Parse(db, r)
+	}
 	if err != nil {
 		return
 	}
 
-	cert, err := ssh.NewCertificate(db, authrs, usr, agnt, c.PubKey)
+	cert, errData, err := ssh.NewCertificate(
+		db, authrs, usr, agnt, c.PubKey, c.Expire)
 	if err != nil {
 		return
 	}
 
+	if errData != nil {
+		c.ErrorData = errData
+
+		err = c.Deny(db, usr)
+		if err != nil {
+			return
+		}
+		return
+	}
+
 	if len(cert.Certificates) == 0 {
 		c.State = ssh.Unavailable
 		c.CertificateId = ""
@@ -190,8 +255,8 @@ func (c *Challenge) Insert(db *database.Database) (err error) {
 	return
 }
 
-func NewChallenge(db *database.Database, pubKey string) (
-	chal *Challenge, err error) {
+func NewChallenge(db *database.Database, pubKey string, expire int,
+	clientIp string) (chal *Challenge, err error) {
 
 	pubKey = strings.TrimSpace(pubKey)
 
@@ -207,10 +272,16 @@ func NewChallenge(db *database.Database, pubKey string) (
 		return
 	}
 
+	if expire < 0 {
+		expire = 0
+	}
+
 	chal = &Challenge{
 		Id:        token,
 		Timestamp: time.Now(),
 		PubKey:    pubKey,
+		Expire:    expire,
+		ClientIp:  clientIp,
 	}
 
 	err = chal.Insert(db)
@@ -0,0 +1,294 @@
+package challenge
+
+import (
+	"crypto/rand"
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/settings"
+	"github.com/hillrnate/pritunl-zero/utils"
+	"gopkg.in/mgo.v2/bson"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const userCodeChars = "BCDFGHJKLMNPQRSTVWXZ"
+
+var userCodeRe = regexp.MustCompile("[^A-Z]+")
+
+type Device struct {
+	Id          string    `bson:"_id"`
+	UserCode    string    `bson:"user_code"`
+	ChallengeId string    `bson:"challenge_id,omitempty"`
+	Timestamp   time.Time `bson:"timestamp"`
+	Expires     time.Time `bson:"expires"`
+	Interval    int       `bson:"interval"`
+	LastPoll    time.Time `bson:"last_poll"`
+	PubKey      string    `bson:"pub_key"`
+	Expire      int       `bson:"expire"`
+	ClientIp    string    `bson:"client_ip"`
+}
+
+func (d *Device) GetUserCode() string {
+	return d.UserCode[:4] + "-" + d.UserCode[4:]
+}
+
+func (d *Device) Poll(db *database.Database) (valid bool, err error) {
+	coll := db.SshDevices()
+	now := time.Now()
+
+	err = coll.Update(&bson.M{
+		"_id": d.Id,
+		"last_poll": &bson.M{
+			"$lte": now.Add(-time.Duration(d.Interval) * time.Second),
+		},
+	}, &bson.M{
+		"$set": &bson.M{
+			"last_poll": now,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		if _, ok := err.(*database.NotFoundError); !ok {
+			return
+		}
+		err = nil
+	} else {
+		d.LastPoll = now
+		valid = true
+		return
+	}
+
+	d.Interval += 5
+
+	err = coll.Update(&bson.M{
+		"_id": d.Id,
+	}, &bson.M{
+		"$set": &bson.M{
+			"interval":  d.Interval,
+			"last_poll": now,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func (d *Device) GetChallenge(db *database.Database) (
+	chal *Challenge, err error) {
+
+	if d.ChallengeId != "" {
+		chal, err = GetChallenge(db, d.ChallengeId)
+		return
+	}
+
+	chal, err = NewChallenge(db, d.PubKey, d.Expire, d.ClientIp)
+	if err != nil {
+		return
+	}
+
+	coll := db.SshDevices()
+
+	err = coll.Update(&bson.M{
+		"_id": d.Id,
+		"challenge_id": &bson.M{
+			"$exists": false,
+		},
+	}, &bson.M{
+		"$set": &bson.M{
+			"challenge_id": chal.Id,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		if _, ok := err.(*database.NotFoundError); !ok {
+			return
+		}
+
+		devc, e := GetDevice(db, d.Id)
+		if e != nil {
+			err = e
+			return
+		}
+
+		chal, err = GetChallenge(db, devc.ChallengeId)
+		return
+	}
+
+	d.ChallengeId = chal.Id
+
+	return
+}
+
+func (d *Device) Commit(db *database.Database) (err error) {
+	coll := db.SshDevices()
+
+	err = coll.Commit(d.Id, d)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (d *Device) CommitFields(db *database.Database, fields set.Set) (
+	err error) {
+
+	coll := db.SshDevices()
+
+	err = coll.CommitFields(d.Id, d, fields)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (d *Device) Insert(db *database.Database) (err error) {
+	coll := db.SshDevices()
+
+	err = coll.Insert(d)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func (d *Device) Remove(db *database.Database) (err error) {
+	coll := db.SshDevices()
+
+	_, err = coll.RemoveAll(&bson.M{
+		"_id": d.Id,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func newUserCode() (code string, err error) {
+	max := big.NewInt(int64(len(userCodeChars)))
+
+	for i := 0; i < 8; i++ {
+		n, e := rand.Int(rand.Reader, max)
+		if e != nil {
+			err = &errortypes.UnknownError{
+				errors.Wrap(e, "sshcert: Random generate error"),
+			}
+			return
+		}
+		code += string(userCodeChars[n.Int64()])
+	}
+
+	return
+}
+
+func NewDevice(db *database.Database, pubKey string, expire int,
+	clientIp string) (devc *Device, err error) {
+
+	pubKey = strings.TrimSpace(pubKey)
+
+	if len(pubKey) > settings.System.SshPubKeyLen {
+		err = errortypes.ParseError{
+			errors.New("sshcert: Public key too long"),
+		}
+		return
+	}
+
+	token, err := utils.RandStr(48)
+	if err != nil {
+		return
+	}
+
+	if expire < 0 {
+		expire = 0
+	}
+
+	devc = &Device{
+		Id:        token,
+		Timestamp: time.Now(),
+		Expires: time.Now().Add(
+			time.Duration(settings.System.SshDeviceExpire) * time.Second),
+		Interval: settings.System.SshDeviceInterval,
+		PubKey:   pubKey,
+		Expire:   expire,
+		ClientIp: clientIp,
+	}
+
+	for i := 0; i < 5; i++ {
+		devc.UserCode, err = newUserCode()
+		if err != nil {
+			return
+		}
+
+		err = devc.Insert(db)
+		if err != nil {
+			if _, ok := err.(*database.DuplicateKeyError); ok {
+				continue
+			}
+			return
+		}
+
+		break
+	}
+
+	return
+}
+
+func GetDevice(db *database.Database, deviceCode string) (
+	devc *Device, err error) {
+
+	coll := db.SshDevices()
+	devc = &Device{}
+
+	err = coll.FindOneId(deviceCode, devc)
+	if err != nil {
+		return
+	}
+
+	if time.Now().After(devc.Expires) {
+		devc = nil
+		err = &database.NotFoundError{
+			errors.New("sshcert: Device code has expired"),
+		}
+		return
+	}
+
+	return
+}
+
+func GetDeviceUserCode(db *database.Database, userCode string) (
+	devc *Device, err error) {
+
+	userCode = userCodeRe.ReplaceAllString(strings.ToUpper(userCode), "")
+
+	coll := db.SshDevices()
+	devc = &Device{}
+
+	err = coll.FindOne(&bson.M{
+		"user_code": userCode,
+	}, devc)
+	if err != nil {
+		return
+	}
+
+	if time.Now().After(devc.Expires) {
+		devc = nil
+		err = &database.NotFoundError{
+			errors.New("sshcert: User code has expired"),
+		}
+		return
+	}
+
+	return
+}
@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/audit"
+	"github.com/hillrnate/pritunl-zero/authority"
+	"github.com/hillrnate/pritunl-zero/constants"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func EmergencySign() (err error) {
+	bundlePath := flag.Arg(1)
+	pubKeyPath := flag.Arg(2)
+	principalsStr := flag.Arg(3)
+	authrKey := flag.Arg(4)
+	expireStr := flag.Arg(5)
+
+	if bundlePath == "" || pubKeyPath == "" || principalsStr == "" ||
+		authrKey == "" {
+
+		err = &errortypes.ReadError{
+			errors.New("cmd.emergency: Usage emergency-sign " +
+				"<bundle_path> <public_key_path> <principals> " +
+				"<authority_fingerprint> [minutes]"),
+		}
+		return
+	}
+
+	principals := []string{}
+	for _, principal := range strings.Split(principalsStr, ",") {
+		principal = strings.TrimSpace(principal)
+		if principal != "" {
+			principals = append(principals, principal)
+		}
+	}
+
+	if len(principals) == 0 {
+		err = &errortypes.ReadError{
+			errors.New("cmd.emergency: Missing principals"),
+		}
+		return
+	}
+
+	expire := 15
+	if expireStr != "" {
+		expire, err = strconv.Atoi(expireStr)
+		if err != nil || expire < 1 || expire > 60 {
+			err = &errortypes.ReadError{
+				errors.New("cmd.emergency: Validity must be 1-60 minutes"),
+			}
+			return
+		}
+	}
+
+	bundleData, err := ioutil.ReadFile(bundlePath)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "cmd.emergency: Failed to read bundle"),
+		}
+		return
+	}
+
+	data := &exportData{}
+	err = json.Unmarshal(bundleData, data)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "cmd.emergency: Failed to parse bundle"),
+		}
+		return
+	}
+
+	if len(data.Keys) == 0 {
+		err = &errortypes.ParseError{
+			errors.New("cmd.emergency: Bundle contains no keys"),
+		}
+		return
+	}
+
+	pubKeyData, err := ioutil.ReadFile(pubKeyPath)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "cmd.emergency: Failed to read public key"),
+		}
+		return
+	}
+
+	pubKey, comment, _, _, err := ssh.ParseAuthorizedKey(pubKeyData)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "cmd.emergency: Failed to parse public key"),
+		}
+		return
+	}
+
+	fmt.Print("Enter bundle passphrase: ")
+	passByt, err := terminal.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "cmd.emergency: Failed to read passphrase"),
+		}
+		return
+	}
+	pass := string(passByt)
+	fmt.Println("")
+
+	operator := ""
+	curUser, e := user.Current()
+	if e == nil {
+		operator = curUser.Username
+	}
+	hostname, _ := os.Hostname()
+
+	validAfter := time.Now().Add(-5 * time.Minute)
+	validBefore := time.Now().Add(time.Duration(expire) * time.Minute)
+	certPath := strings.TrimSuffix(pubKeyPath, ".pub") + "-cert.pub"
+
+	var signer ssh.Signer
+	fingerprints := []string{}
+
+	for _, encKey := range data.Keys {
+		key, e := authority.ImportKey(encKey, pass)
+		if e != nil {
+			err = e
+			return
+		}
+
+		keySigner, e := ssh.NewSignerFromKey(key)
+		if e != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(e, "cmd.emergency: Failed to create signer"),
+			}
+			return
+		}
+
+		fingerprint := ssh.FingerprintSHA256(keySigner.PublicKey())
+		if fingerprint == authrKey {
+			signer = keySigner
+			break
+		}
+		fingerprints = append(fingerprints, fingerprint)
+	}
+
+	if signer == nil {
+		err = &errortypes.NotFoundError{
+			errors.Newf("cmd.emergency: Authority key not in bundle, "+
+				"available keys %s", strings.Join(fingerprints, ", ")),
+		}
+		return
+	}
+
+	serialByt := make([]byte, 8)
+	_, err = rand.Read(serialByt)
+	if err != nil {
+		err = &errortypes.UnknownError{
+			errors.Wrap(err, "cmd.emergency: Failed to generate serial"),
+		}
+		return
+	}
+	serial := binary.BigEndian.Uint64(serialByt)
+
+	cert := &ssh.Certificate{
+		Key:             pubKey,
+		Serial:          serial,
+		CertType:        ssh.UserCert,
+		KeyId:           "emergency-" + operator,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(validAfter.Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{},
+		},
+	}
+
+	for _, extension := range authority.DefaultExtensions {
+		cert.Permissions.Extensions[extension] = ""
+	}
+
+	err = cert.SignCert(rand.Reader, signer)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "cmd.emergency: Failed to sign certificate"),
+		}
+		return
+	}
+
+	certFile, err := os.OpenFile(certPath,
+		os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "cmd.emergency: Failed to create certificate, "+
+				"existing certificates are not replaced"),
+		}
+		return
+	}
+	defer certFile.Close()
+
+	err = audit.NewLocal(constants.EmergencyPath, audit.EmergencySign,
+		audit.Fields{
+			"operator":      operator,
+			"hostname":      hostname,
+			"authority_key": authrKey,
+			"public_key":    ssh.FingerprintSHA256(pubKey),
+			"serial":        serial,
+			"principals":    principals,
+			"valid_before":  validBefore,
+		},
+	)
+	if err != nil {
+		certFile.Close()
+		os.Remove(certPath)
+		return
+	}
+
+	_, err = certFile.Write(authority.MarshalCertificate(cert, comment))
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "cmd.emergency: Failed to write certificate"),
+		}
+		return
+	}
+
+	fmt.Printf("Signed with %s, wrote certificate to %s\n",
+		authrKey, certPath)
+	fmt.Printf("Certificate expires at %s\n",
+		validBefore.Format(time.RFC3339))
+
+	return
+}
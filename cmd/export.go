@@ -49,6 +49,10 @@ func ExportSsh() (err error) {
 	keys := []string{}
 
 	for _, authr := range authrs {
+		if authr.Type != authority.Local {
+			continue
+		}
+
 		key, e := authr.Export(pass)
 		if e != nil {
 			err = e
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/hostagent"
+)
+
+func HostAgent() (err error) {
+	confPath := flag.Arg(1)
+
+	if confPath == "" {
+		err = &errortypes.ReadError{
+			errors.New("cmd.hostagent: Missing config path"),
+		}
+		return
+	}
+
+	conf, err := hostagent.LoadConfig(confPath)
+	if err != nil {
+		return
+	}
+
+	agnt, err := hostagent.New(conf)
+	if err != nil {
+		return
+	}
+
+	err = agnt.Run()
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// Sshd must be configured with the arguments %u %i
+func HostPrincipals() (err error) {
+	confPath := flag.Arg(1)
+	account := flag.Arg(2)
+	keyId := flag.Arg(3)
+
+	if confPath == "" || account == "" {
+		err = &errortypes.ReadError{
+			errors.New("cmd.hostagent: Usage host-principals " +
+				"<config_path> <account> <key_id>"),
+		}
+		return
+	}
+
+	conf, err := hostagent.LoadConfig(confPath)
+	if err != nil {
+		return
+	}
+
+	agnt, err := hostagent.New(conf)
+	if err != nil {
+		return
+	}
+
+	principals, err := agnt.Principals(account, keyId)
+	if err != nil {
+		return
+	}
+
+	for _, principal := range principals {
+		fmt.Println(principal)
+	}
+
+	return
+}
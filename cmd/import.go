@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/audit"
+	"github.com/hillrnate/pritunl-zero/authority"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/event"
+	"golang.org/x/crypto/ssh/terminal"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"syscall"
+)
+
+func ImportSsh() (err error) {
+	authrIdStr := flag.Arg(1)
+	keyPath := flag.Arg(2)
+
+	if !bson.IsObjectIdHex(authrIdStr) {
+		err = &errortypes.ReadError{
+			errors.New("cmd.import: Invalid authority ID"),
+		}
+		return
+	}
+	authrId := bson.ObjectIdHex(authrIdStr)
+
+	if keyPath == "" {
+		err = &errortypes.ReadError{
+			errors.New("cmd.import: Missing private key path"),
+		}
+		return
+	}
+
+	keyData, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "cmd.import: Failed to read private key"),
+		}
+		return
+	}
+
+	db := database.GetDatabase()
+	defer db.Close()
+
+	authr, err := authority.Get(db, authrId)
+	if err != nil {
+		return
+	}
+
+	if authr.Type != authority.Local {
+		err = &errortypes.WriteError{
+			errors.New("cmd.import: Keys can only be imported to local " +
+				"authorities"),
+		}
+		return
+	}
+
+	fmt.Print("Enter key passphrase (leave blank if unencrypted): ")
+	passByt, err := terminal.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "cmd.import: Failed to read passphrase"),
+		}
+		return
+	}
+	pass := string(passByt)
+	fmt.Println("")
+
+	errData, err := authr.ImportPrivateKey(string(keyData), pass)
+	if err != nil {
+		return
+	}
+
+	if errData != nil {
+		err = &errortypes.ParseError{
+			errors.Newf("cmd.import: %s", errData.Message),
+		}
+		return
+	}
+
+	err = authr.CommitFields(db, authority.RotateFields)
+	if err != nil {
+		return
+	}
+
+	err = audit.NewSystem(
+		db,
+		audit.AuthorityKeyImport,
+		audit.Fields{
+			"authority_id": authr.Id,
+			"key_alg":      authr.Info.KeyAlg,
+			"public_key":   authr.PublicKey,
+			"source":       "cli",
+		},
+	)
+	if err != nil {
+		return
+	}
+
+	event.PublishDispatch(db, "authority.change")
+
+	fmt.Printf("Successfully imported %s key into %s\n",
+		authr.Info.KeyAlg, authr.Name)
+	fmt.Println(authr.PublicKey)
+
+	return
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"github.com/Sirupsen/logrus"
+	"github.com/hillrnate/pritunl-zero/bastion"
 	"github.com/hillrnate/pritunl-zero/config"
 	"github.com/hillrnate/pritunl-zero/constants"
 	"github.com/hillrnate/pritunl-zero/node"
@@ -37,9 +38,14 @@ func Node() (err error) {
 		}
 	}()
 
+	bstn := &bastion.Bastion{}
+
+	go bstn.Run()
+
 	<-sig
 	logrus.Info("cmd.node: Shutting down")
 	go routr.Shutdown()
+	go bstn.Shutdown()
 	if constants.Production {
 		time.Sleep(10 * time.Second)
 	} else {
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"flag"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/sshclient"
+	"strings"
+)
+
+func SshLogin() (err error) {
+	opts := &sshclient.Options{}
+
+	for _, arg := range flag.Args()[1:] {
+		switch arg {
+		case "--device":
+			opts.Device = true
+			break
+		case "--agent":
+			opts.Agent = true
+			break
+		default:
+			if strings.HasPrefix(arg, "--") {
+				err = &errortypes.ReadError{
+					errors.Newf("cmd.sshlogin: Unknown option %s", arg),
+				}
+				return
+			}
+
+			if opts.Server == "" {
+				opts.Server = arg
+			} else if opts.KeyPath == "" {
+				opts.KeyPath = arg
+			}
+		}
+	}
+
+	if opts.Server == "" {
+		err = &errortypes.ReadError{
+			errors.New("cmd.sshlogin: Usage ssh-login " +
+				"<server> [key_path] [--device] [--agent]"),
+		}
+		return
+	}
+
+	if !strings.HasPrefix(opts.Server, "https://") &&
+		!strings.HasPrefix(opts.Server, "http://") {
+
+		opts.Server = "https://" + opts.Server
+	}
+
+	err = sshclient.Login(opts)
+	if err != nil {
+		return
+	}
+
+	return
+}
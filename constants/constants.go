@@ -11,6 +11,8 @@ const (
 	ConfPath        = "/etc/pritunl-zero.json"
 	LogPath         = "/var/log/pritunl-zero.log"
 	LogPath2        = "/var/log/pritunl-zero.log.1"
+	EmergencyPath   = "/var/log/pritunl-zero-emergency.log"
+	RecordingPath   = "/var/lib/pritunl-zero/recordings"
 	TempPath        = "/tmp/pritunl-zero"
 	StaticCache     = true
 	RetryDelay      = 3 * time.Second
@@ -112,11 +112,46 @@ func (d *Database) SshChallenges() (coll *Collection) {
 	return
 }
 
+func (d *Database) SshDevices() (coll *Collection) {
+	coll = d.getCollection("ssh_devices")
+	return
+}
+
+func (d *Database) SshKeys() (coll *Collection) {
+	coll = d.getCollection("ssh_keys")
+	return
+}
+
 func (d *Database) SshCertificates() (coll *Collection) {
 	coll = d.getCollection("ssh_certificates")
 	return
 }
 
+func (d *Database) SshRevocations() (coll *Collection) {
+	coll = d.getCollection("ssh_revocations")
+	return
+}
+
+func (d *Database) SshKrlVersions() (coll *Collection) {
+	coll = d.getCollection("ssh_krl_versions")
+	return
+}
+
+func (d *Database) SshHosts() (coll *Collection) {
+	coll = d.getCollection("ssh_hosts")
+	return
+}
+
+func (d *Database) SshRecordings() (coll *Collection) {
+	coll = d.getCollection("ssh_recordings")
+	return
+}
+
+func (d *Database) SshRecordingsGridFs() (gfs *mgo.GridFS) {
+	gfs = d.database.GridFS("ssh_recordings_fs")
+	return
+}
+
 func (d *Database) KeybaseChallenges() (coll *Collection) {
 	coll = d.getCollection("keybase_challenges")
 	return
@@ -384,7 +419,7 @@ func addIndexes() (err error) {
 
 	coll = db.Authorities()
 	err = coll.EnsureIndex(mgo.Index{
-		Key:        []string{"host_tokens"},
+		Key:        []string{"host_tokens.token"},
 		Background: true,
 	})
 	if err != nil {
@@ -405,6 +440,40 @@ func addIndexes() (err error) {
 		}
 	}
 
+	coll = db.SshDevices()
+	err = coll.EnsureIndex(mgo.Index{
+		Key:        []string{"user_code"},
+		Unique:     true,
+		Background: true,
+	})
+	if err != nil {
+		err = &IndexError{
+			errors.Wrap(err, "database: Index error"),
+		}
+	}
+	err = coll.EnsureIndex(mgo.Index{
+		Key:         []string{"expires"},
+		ExpireAfter: 1 * time.Second,
+		Background:  true,
+	})
+	if err != nil {
+		err = &IndexError{
+			errors.Wrap(err, "database: Index error"),
+		}
+	}
+
+	coll = db.SshKeys()
+	err = coll.EnsureIndex(mgo.Index{
+		Key:        []string{"user_id", "fingerprint"},
+		Unique:     true,
+		Background: true,
+	})
+	if err != nil {
+		err = &IndexError{
+			errors.Wrap(err, "database: Index error"),
+		}
+	}
+
 	coll = db.SshCertificates()
 	err = coll.EnsureIndex(mgo.Index{
 		Key:         []string{"timestamp"},
@@ -416,6 +485,117 @@ func addIndexes() (err error) {
 			errors.Wrap(err, "database: Index error"),
 		}
 	}
+	err = coll.EnsureIndex(mgo.Index{
+		Key: []string{
+			"certificates_info.authority_id",
+			"certificates_info.serial",
+		},
+		Background: true,
+	})
+	if err != nil {
+		err = &IndexError{
+			errors.Wrap(err, "database: Index error"),
+		}
+	}
+	err = coll.EnsureIndex(mgo.Index{
+		Key:        []string{"fingerprint"},
+		Background: true,
+	})
+	if err != nil {
+		err = &IndexError{
+			errors.Wrap(err, "database: Index error"),
+		}
+	}
+	err = coll.EnsureIndex(mgo.Index{
+		Key:        []string{"config_token"},
+		Sparse:     true,
+		Background: true,
+	})
+	if err != nil {
+		err = &IndexError{
+			errors.Wrap(err, "database: Index error"),
+		}
+	}
+	err = coll.EnsureIndex(mgo.Index{
+		Key:        []string{"user_id"},
+		Background: true,
+	})
+	if err != nil {
+		err = &IndexError{
+			errors.Wrap(err, "database: Index error"),
+		}
+	}
+
+	coll = db.SshRevocations()
+	err = coll.EnsureIndex(mgo.Index{
+		Key:        []string{"authority_id"},
+		Background: true,
+	})
+	if err != nil {
+		err = &IndexError{
+			errors.Wrap(err, "database: Index error"),
+		}
+	}
+	err = coll.EnsureIndex(mgo.Index{
+		Key:         []string{"expires"},
+		ExpireAfter: 1 * time.Second,
+		Background:  true,
+	})
+	if err != nil {
+		err = &IndexError{
+			errors.Wrap(err, "database: Index error"),
+		}
+	}
+
+	coll = db.SshHosts()
+	err = coll.EnsureIndex(mgo.Index{
+		Key:        []string{"authority_id", "hostname"},
+		Unique:     true,
+		Background: true,
+	})
+	if err != nil {
+		err = &IndexError{
+			errors.Wrap(err, "database: Index error"),
+		}
+	}
+	err = coll.EnsureIndex(mgo.Index{
+		Key:        []string{"last_seen"},
+		Background: true,
+	})
+	if err != nil {
+		err = &IndexError{
+			errors.Wrap(err, "database: Index error"),
+		}
+	}
+
+	coll = db.SshRecordings()
+	err = coll.EnsureIndex(mgo.Index{
+		Key:        []string{"user_id"},
+		Background: true,
+	})
+	if err != nil {
+		err = &IndexError{
+			errors.Wrap(err, "database: Index error"),
+		}
+	}
+	err = coll.EnsureIndex(mgo.Index{
+		Key:        []string{"serial"},
+		Background: true,
+	})
+	if err != nil {
+		err = &IndexError{
+			errors.Wrap(err, "database: Index error"),
+		}
+	}
+	err = coll.EnsureIndex(mgo.Index{
+		Key:        []string{"timestamp"},
+		Background: true,
+	})
+	if err != nil {
+		err = &IndexError{
+			errors.Wrap(err, "database: Index error"),
+		}
+	}
 
 	coll = db.KeybaseChallenges()
 	err = coll.EnsureIndex(mgo.Index{
@@ -0,0 +1,51 @@
+package host
+
+import (
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/hillrnate/pritunl-zero/database"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+type Host struct {
+	Id          bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	AuthorityId bson.ObjectId `bson:"authority_id" json:"authority_id"`
+	Hostname    string        `bson:"hostname" json:"hostname"`
+	Principals  []string      `bson:"principals" json:"principals"`
+	Fingerprint string        `bson:"fingerprint" json:"fingerprint"`
+	Ip          string        `bson:"ip" json:"ip"`
+	TokenId     bson.ObjectId `bson:"token_id,omitempty" json:"token_id"`
+	FirstSeen   time.Time     `bson:"first_seen" json:"first_seen"`
+	LastSeen    time.Time     `bson:"last_seen" json:"last_seen"`
+	Expires     time.Time     `bson:"expires" json:"expires"`
+	Blocked     bool          `bson:"blocked" json:"blocked"`
+	Stale       bool          `bson:"-" json:"stale"`
+}
+
+func (h *Host) Format() {
+	h.Stale = time.Now().After(h.Expires)
+}
+
+func (h *Host) Commit(db *database.Database) (err error) {
+	coll := db.SshHosts()
+
+	err = coll.Commit(h.Id, h)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (h *Host) CommitFields(db *database.Database, fields set.Set) (
+	err error) {
+
+	coll := db.SshHosts()
+
+	err = coll.CommitFields(h.Id, h, fields)
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,126 @@
+package host
+
+import (
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/utils"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+func Get(db *database.Database, hostId bson.ObjectId) (
+	hst *Host, err error) {
+
+	coll := db.SshHosts()
+	hst = &Host{}
+
+	err = coll.FindOneId(hostId, hst)
+	if err != nil {
+		return
+	}
+
+	hst.Format()
+
+	return
+}
+
+func GetAll(db *database.Database, query *bson.M, page, pageCount int) (
+	hosts []*Host, count int, err error) {
+
+	coll := db.SshHosts()
+	hosts = []*Host{}
+
+	qury := coll.Find(query)
+
+	count, err = qury.Count()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	skip := utils.Min(page*pageCount, utils.Max(0, count-pageCount))
+
+	cursor := qury.Sort("hostname").Skip(skip).Limit(pageCount).Iter()
+
+	hst := &Host{}
+	for cursor.Next(hst) {
+		hst.Format()
+		hosts = append(hosts, hst)
+		hst = &Host{}
+	}
+
+	err = cursor.Close()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func IsBlocked(db *database.Database, authrId bson.ObjectId,
+	hostname string) (blocked bool, err error) {
+
+	coll := db.SshHosts()
+
+	count, err := coll.Find(&bson.M{
+		"authority_id": authrId,
+		"hostname":     hostname,
+		"blocked":      true,
+	}).Count()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	blocked = count > 0
+
+	return
+}
+
+func Seen(db *database.Database, authrId bson.ObjectId, hostname string,
+	principals []string, fingerprint, ip string, tokenId bson.ObjectId,
+	expires time.Time) (err error) {
+
+	coll := db.SshHosts()
+	now := time.Now()
+
+	_, err = coll.Upsert(&bson.M{
+		"authority_id": authrId,
+		"hostname":     hostname,
+	}, &bson.M{
+		"$set": &bson.M{
+			"principals":  principals,
+			"fingerprint": fingerprint,
+			"ip":          ip,
+			"token_id":    tokenId,
+			"last_seen":   now,
+			"expires":     expires,
+		},
+		"$setOnInsert": &bson.M{
+			"first_seen": now,
+			"blocked":    false,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+// Blocked hosts are kept to prevent the host from registering again
+func Remove(db *database.Database, hostId bson.ObjectId) (err error) {
+	coll := db.SshHosts()
+
+	_, err = coll.RemoveAll(&bson.M{
+		"_id":     hostId,
+		"blocked": false,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
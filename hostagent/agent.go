@@ -0,0 +1,342 @@
+package hostagent
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+type challengeData struct {
+	PublicKey string `json:"public_key"`
+}
+
+type hostData struct {
+	Hostname   string   `json:"hostname"`
+	Port       int      `json:"port"`
+	Principals []string `json:"principals"`
+	Tokens     []string `json:"tokens"`
+	PublicKey  string   `json:"public_key"`
+}
+
+type certificateData struct {
+	Certificates []string `json:"certificates"`
+	PublicKeys   []string `json:"public_keys"`
+	Errors       []string `json:"errors"`
+}
+
+type Agent struct {
+	conf   *Config
+	client *http.Client
+	pubKey string
+}
+
+func (a *Agent) challengeGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(405)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&challengeData{
+		PublicKey: a.pubKey,
+	})
+}
+
+func (a *Agent) serve(listener net.Listener) (err error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/challenge", a.challengeGet)
+
+	server := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+
+	if a.conf.ChallengeCert != "" {
+		err = server.ServeTLS(
+			listener, a.conf.ChallengeCert, a.conf.ChallengeKey)
+	} else {
+		err = server.Serve(listener)
+	}
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "hostagent: Challenge server error"),
+		}
+		return
+	}
+
+	return
+}
+
+func (a *Agent) request() (data *certificateData, err error) {
+	reqData, err := json.Marshal(&hostData{
+		Hostname:   a.conf.Hostname,
+		Port:       a.conf.Port,
+		Principals: a.conf.Principals,
+		Tokens:     a.conf.Tokens,
+		PublicKey:  a.pubKey,
+	})
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "hostagent: Failed to marshal request"),
+		}
+		return
+	}
+
+	req, err := http.NewRequest(
+		"POST",
+		a.conf.Server+"/ssh/host",
+		bytes.NewReader(reqData),
+	)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "hostagent: Failed to create request"),
+		}
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "hostagent: Certificate request failed"),
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 400 {
+		errData := &errortypes.ErrorData{}
+		_ = json.NewDecoder(resp.Body).Decode(errData)
+
+		err = &errortypes.RequestError{
+			errors.Newf("hostagent: Certificate request rejected %s",
+				errData.Message),
+		}
+		return
+	}
+
+	if resp.StatusCode != 200 {
+		err = &errortypes.RequestError{
+			errors.Newf("hostagent: Certificate request bad status %d",
+				resp.StatusCode),
+		}
+		return
+	}
+
+	data = &certificateData{}
+	err = json.NewDecoder(resp.Body).Decode(data)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "hostagent: Failed to parse response"),
+		}
+		return
+	}
+
+	if len(data.Certificates) == 0 {
+		err = &errortypes.RequestError{
+			errors.New("hostagent: No certificates in response"),
+		}
+		return
+	}
+
+	return
+}
+
+func (a *Agent) install(data *certificateData) (changed bool, err error) {
+	for i, cert := range data.Certificates {
+		path := a.conf.CertificatePath
+		if i > 0 {
+			path = fmt.Sprintf("%s.%d", path, i)
+		}
+
+		updated, e := writeFile(path, cert+"\n")
+		if e != nil {
+			err = e
+			return
+		}
+		changed = changed || updated
+	}
+
+	updated, err := writeFile(a.conf.TrustedCaPath,
+		strings.Join(data.PublicKeys, "\n")+"\n")
+	if err != nil {
+		return
+	}
+	changed = changed || updated
+
+	if changed && len(a.conf.ReloadCommand) > 0 {
+		cmd := exec.Command(a.conf.ReloadCommand[0],
+			a.conf.ReloadCommand[1:]...)
+		output, e := cmd.CombinedOutput()
+		if e != nil {
+			err = &errortypes.WriteError{
+				errors.Wrapf(e, "hostagent: Reload command failed '%s'",
+					strings.TrimSpace(string(output))),
+			}
+			return
+		}
+	}
+
+	return
+}
+
+func (a *Agent) renew() (renewAt time.Time, err error) {
+	issued := time.Now()
+
+	data, err := a.request()
+	if err != nil {
+		return
+	}
+
+	for _, reason := range data.Errors {
+		logrus.WithFields(logrus.Fields{
+			"reason": reason,
+		}).Warning("hostagent: Server reported validation error")
+	}
+
+	var expires time.Time
+	serials := []uint64{}
+
+	for _, certStr := range data.Certificates {
+		pubKey, _, _, _, e := ssh.ParseAuthorizedKey([]byte(certStr))
+		if e != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(e, "hostagent: Failed to parse certificate"),
+			}
+			return
+		}
+
+		cert, ok := pubKey.(*ssh.Certificate)
+		if !ok {
+			err = &errortypes.ParseError{
+				errors.New("hostagent: Response is not a certificate"),
+			}
+			return
+		}
+		serials = append(serials, cert.Serial)
+
+		// Compare before converting, CertTimeInfinity overflows the time
+		if cert.ValidBefore >= uint64(issued.Add(maxRenew).Unix()) {
+			continue
+		}
+
+		certExpires := time.Unix(int64(cert.ValidBefore), 0)
+		if expires.IsZero() || certExpires.Before(expires) {
+			expires = certExpires
+		}
+	}
+
+	changed, err := a.install(data)
+	if err != nil {
+		return
+	}
+
+	renewIn := maxRenew
+	if !expires.IsZero() {
+		renewIn = expires.Sub(issued) * 2 / 3
+	}
+	if renewIn < minRenew {
+		renewIn = minRenew
+	}
+	renewAt = issued.Add(renewIn)
+
+	logrus.WithFields(logrus.Fields{
+		"serials":  serials,
+		"expires":  expires,
+		"renew_at": renewAt,
+		"changed":  changed,
+	}).Info("hostagent: Installed host certificate")
+
+	return
+}
+
+func (a *Agent) Run() (err error) {
+	pubKeyData, err := ioutil.ReadFile(a.conf.PublicKeyPath)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "hostagent: Failed to read host public key"),
+		}
+		return
+	}
+	a.pubKey = strings.TrimSpace(string(pubKeyData))
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", a.conf.Port))
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "hostagent: Failed to listen for challenge"),
+		}
+		return
+	}
+
+	go func() {
+		e := a.serve(listener)
+		if e != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": e,
+			}).Error("hostagent: Challenge server stopped")
+		}
+	}()
+
+	for {
+		renewAt, e := a.renew()
+		if e != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": e,
+			}).Error("hostagent: Failed to renew host certificate")
+
+			time.Sleep(1 * time.Minute)
+			continue
+		}
+
+		time.Sleep(time.Until(renewAt))
+	}
+}
+
+func New(conf *Config) (agnt *Agent, err error) {
+	tlsConf := &tls.Config{}
+
+	if conf.ServerCa != "" {
+		caData, e := ioutil.ReadFile(conf.ServerCa)
+		if e != nil {
+			err = &errortypes.ReadError{
+				errors.Wrap(e, "hostagent: Failed to read server ca"),
+			}
+			return
+		}
+
+		caPool := x509.NewCertPool()
+		if ok := caPool.AppendCertsFromPEM(caData); !ok {
+			err = &errortypes.ParseError{
+				errors.New("hostagent: Failed to parse server ca"),
+			}
+			return
+		}
+
+		tlsConf.RootCAs = caPool
+	}
+
+	agnt = &Agent{
+		conf: conf,
+		client: &http.Client{
+			Timeout: 60 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConf,
+			},
+		},
+	}
+
+	return
+}
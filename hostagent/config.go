@@ -0,0 +1,101 @@
+package hostagent
+
+import (
+	"encoding/json"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"io/ioutil"
+	"strings"
+)
+
+type Config struct {
+	Server          string   `json:"server"`
+	ServerCa        string   `json:"server_ca"`
+	Hostname        string   `json:"hostname"`
+	Port            int      `json:"port"`
+	Principals      []string `json:"principals"`
+	Tokens          []string `json:"tokens"`
+	PublicKeyPath   string   `json:"public_key_path"`
+	CertificatePath string   `json:"certificate_path"`
+	TrustedCaPath   string   `json:"trusted_ca_path"`
+	ChallengeCert   string   `json:"challenge_cert"`
+	ChallengeKey    string   `json:"challenge_key"`
+	ReloadCommand   []string `json:"reload_command"`
+}
+
+func (c *Config) Validate() (err error) {
+	c.Server = strings.TrimRight(strings.TrimSpace(c.Server), "/")
+
+	if c.Server == "" {
+		err = &errortypes.ParseError{
+			errors.New("hostagent: Server must be set"),
+		}
+		return
+	}
+
+	if c.Hostname == "" {
+		err = &errortypes.ParseError{
+			errors.New("hostagent: Hostname must be set"),
+		}
+		return
+	}
+
+	if len(c.Tokens) == 0 {
+		err = &errortypes.ParseError{
+			errors.New("hostagent: At least one token must be set"),
+		}
+		return
+	}
+
+	if c.Port == 0 {
+		c.Port = 9748
+	}
+
+	if c.PublicKeyPath == "" {
+		c.PublicKeyPath = "/etc/ssh/ssh_host_ed25519_key.pub"
+	}
+
+	if c.CertificatePath == "" {
+		c.CertificatePath = strings.TrimSuffix(
+			c.PublicKeyPath, ".pub") + "-cert.pub"
+	}
+
+	if c.TrustedCaPath == "" {
+		c.TrustedCaPath = "/etc/ssh/trusted_user_ca_keys"
+	}
+
+	if (c.ChallengeCert == "") != (c.ChallengeKey == "") {
+		err = &errortypes.ParseError{
+			errors.New("hostagent: Challenge cert and key must both be set"),
+		}
+		return
+	}
+
+	return
+}
+
+func LoadConfig(path string) (conf *Config, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "hostagent: Failed to read config"),
+		}
+		return
+	}
+
+	conf = &Config{}
+	err = json.Unmarshal(data, conf)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "hostagent: Failed to parse config"),
+		}
+		return
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,10 @@
+package hostagent
+
+import (
+	"time"
+)
+
+const (
+	minRenew = 1 * time.Minute
+	maxRenew = 24 * time.Hour
+)
@@ -0,0 +1,97 @@
+package hostagent
+
+import (
+	"bufio"
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+func (a *Agent) principals(token, account, keyId string) (
+	principals []string, err error) {
+
+	query := url.Values{}
+	query.Set("hostname", a.conf.Hostname)
+	query.Set("account", account)
+	query.Set("key_id", keyId)
+
+	req, err := http.NewRequest(
+		"GET",
+		a.conf.Server+"/ssh/principals?"+query.Encode(),
+		nil,
+	)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "hostagent: Failed to create request"),
+		}
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "hostagent: Principals request failed"),
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		err = &errortypes.RequestError{
+			errors.Newf("hostagent: Principals request bad status %d",
+				resp.StatusCode),
+		}
+		return
+	}
+
+	principals = []string{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		principal := strings.TrimSpace(scanner.Text())
+		if principal != "" {
+			principals = append(principals, principal)
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "hostagent: Failed to read principals"),
+		}
+		return
+	}
+
+	return
+}
+
+func (a *Agent) Principals(account, keyId string) (
+	principals []string, err error) {
+
+	principals = []string{}
+	found := set.NewSet()
+
+	for _, token := range a.conf.Tokens {
+		tokenPrincipals, e := a.principals(token, account, keyId)
+		if e != nil {
+			err = e
+			continue
+		}
+
+		for _, principal := range tokenPrincipals {
+			if !found.Contains(principal) {
+				found.Add(principal)
+				principals = append(principals, principal)
+			}
+		}
+	}
+
+	if len(principals) > 0 {
+		err = nil
+	}
+
+	return
+}
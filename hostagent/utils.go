@@ -0,0 +1,38 @@
+package hostagent
+
+import (
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"io/ioutil"
+	"os"
+)
+
+// Replaced with a rename to avoid sshd reading a partial file
+func writeFile(path, data string) (changed bool, err error) {
+	current, e := ioutil.ReadFile(path)
+	if e == nil && string(current) == data {
+		return
+	}
+
+	tmpPath := path + ".tmp"
+
+	err = ioutil.WriteFile(tmpPath, []byte(data), 0644)
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "hostagent: Failed to write file"),
+		}
+		return
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "hostagent: Failed to rename file"),
+		}
+		return
+	}
+
+	changed = true
+
+	return
+}
@@ -29,6 +29,7 @@ type Challenge struct {
 	Timestamp time.Time              `bson:"timestamp"`
 	State     string                 `bson:"state"`
 	PubKey    string                 `bson:"pub_key"`
+	ErrorData *errortypes.ErrorData  `bson:"error_data,omitempty"`
 }
 
 func (c *Challenge) Message() string {
@@ -222,12 +223,16 @@ func (c *Challenge) NewCertificate(db *database.Database, r *http.Request) (
 		return
 	}
 
-	cert, err := ssh.NewCertificate(db, authrs, usr, agnt, c.PubKey)
+	cert, errData, err := ssh.NewCertificate(
+		db, authrs, usr, agnt, c.PubKey, 0)
 	if err != nil {
 		return
 	}
 
-	if len(cert.Certificates) == 0 {
+	if errData != nil {
+		c.State = ssh.Denied
+		c.ErrorData = errData
+	} else if len(cert.Certificates) == 0 {
 		c.State = ssh.Unavailable
 	} else {
 		err = cert.Insert(db)
@@ -250,6 +255,10 @@ func (c *Challenge) NewCertificate(db *database.Database, r *http.Request) (
 		return
 	}
 
+	if errData != nil {
+		return
+	}
+
 	if len(cert.Certificates) == 0 {
 		errData = &errortypes.ErrorData{
 			Error: "certificate_unavailable",
@@ -15,13 +15,18 @@ const help = `
 Usage: pritunl-zero COMMAND
 
 Commands:
-  version     Show version
-  mongo       Set MongoDB URI
-  set         Set a setting
-  unset       Unset a setting
-  start       Start node
-  clear-logs  Clear logs
-  export-ssh  Export SSH authorities for emergency client
+  version         Show version
+  mongo           Set MongoDB URI
+  set             Set a setting
+  unset           Unset a setting
+  start           Start node
+  clear-logs      Clear logs
+  export-ssh      Export SSH authorities for emergency client
+  import-ssh      Import SSH private key into authority
+  emergency-sign  Sign SSH key with export-ssh bundle without database
+  host-agent      Run host certificate renewal agent
+  host-principals Print SSH principals for sshd principals command
+  ssh-login       Request SSH certificates for local key
 `
 
 func Init() {
@@ -85,6 +90,38 @@ func main() {
 			panic(err)
 		}
 		return
+	case "import-ssh":
+		Init()
+		err := cmd.ImportSsh()
+		if err != nil {
+			panic(err)
+		}
+		return
+	case "emergency-sign":
+		logger.Init()
+		err := cmd.EmergencySign()
+		if err != nil {
+			panic(err)
+		}
+		return
+	case "host-agent":
+		err := cmd.HostAgent()
+		if err != nil {
+			panic(err)
+		}
+		return
+	case "host-principals":
+		err := cmd.HostPrincipals()
+		if err != nil {
+			panic(err)
+		}
+		return
+	case "ssh-login":
+		err := cmd.SshLogin()
+		if err != nil {
+			panic(err)
+		}
+		return
 	case "clear-logs":
 		Init()
 		err := cmd.ClearLogs()
@@ -3,27 +3,52 @@ package mhandlers
 import (
 	"github.com/dropbox/godropbox/container/set"
 	"github.com/gin-gonic/gin"
+	"github.com/hillrnate/pritunl-zero/audit"
 	"github.com/hillrnate/pritunl-zero/authority"
+	"github.com/hillrnate/pritunl-zero/authorizer"
 	"github.com/hillrnate/pritunl-zero/database"
 	"github.com/hillrnate/pritunl-zero/demo"
+	"github.com/hillrnate/pritunl-zero/errortypes"
 	"github.com/hillrnate/pritunl-zero/event"
+	"github.com/hillrnate/pritunl-zero/user"
 	"github.com/hillrnate/pritunl-zero/utils"
 	"gopkg.in/mgo.v2/bson"
 	"strings"
+	"time"
 )
 
 type authorityData struct {
-	Id                 bson.ObjectId `json:"id"`
-	Name               string        `json:"name"`
-	Type               string        `json:"type"`
-	Expire             int           `json:"expire"`
-	HostExpire         int           `json:"host_expire"`
-	MatchRoles         bool          `json:"match_roles"`
-	Roles              []string      `json:"roles"`
-	HostDomain         string        `json:"host_domain"`
-	HostProxy          string        `json:"host_proxy"`
-	HostCertificates   bool          `json:"host_certificates"`
-	StrictHostChecking bool          `json:"strict_host_checking"`
+	Id                 bson.ObjectId                 `json:"id"`
+	Name               string                        `json:"name"`
+	Type               string                        `json:"type"`
+	KeyAlg             string                        `json:"key_alg"`
+	Expire             int                           `json:"expire"`
+	HostExpire         int                           `json:"host_expire"`
+	MatchRoles         bool                          `json:"match_roles"`
+	Roles              []string                      `json:"roles"`
+	HostDomain         string                        `json:"host_domain"`
+	HostProxy          string                        `json:"host_proxy"`
+	HostCertificates   bool                          `json:"host_certificates"`
+	StrictHostChecking bool                          `json:"strict_host_checking"`
+	RegisteredKeys     bool                          `json:"registered_keys"`
+	KeyApproval        bool                          `json:"key_approval"`
+	RecordingRequired  bool                          `json:"recording_required"`
+	HostPrincipals     []string                      `json:"host_principals"`
+	HostChallengeHttps bool                          `json:"host_challenge_https"`
+	HostChallengeCa    string                        `json:"host_challenge_ca"`
+	Extensions         []string                      `json:"extensions"`
+	ForceCommand       string                        `json:"force_command"`
+	SourceAddress      []string                      `json:"source_address"`
+	SourceClientIp     bool                          `json:"source_client_ip"`
+	RoleAddresses      []*authority.RoleAddress      `json:"role_addresses"`
+	PrincipalMappings  []*authority.PrincipalMapping `json:"principal_mappings"`
+	Pkcs11Module       string                        `json:"pkcs11_module"`
+	Pkcs11Token        string                        `json:"pkcs11_token"`
+	Pkcs11Key          string                        `json:"pkcs11_key"`
+	Pkcs11Pin          string                        `json:"pkcs11_pin"`
+	RemoteUrl          string                        `json:"remote_url"`
+	RemoteToken        string                        `json:"remote_token"`
+	RemoteCa           string                        `json:"remote_ca"`
 }
 
 func authorityPut(c *gin.Context) {
@@ -52,15 +77,33 @@ func authorityPut(c *gin.Context) {
 		return
 	}
 
+	if authr.Type == "" {
+		authr.Type = authority.Local
+	}
+	if data.Type == "" {
+		data.Type = authr.Type
+	}
+
+	if data.Type != authr.Type {
+		errData := &errortypes.ErrorData{
+			Error:   "type_change_invalid",
+			Message: "Authority type cannot be changed",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	remoteChanged := data.Type == authority.Remote &&
+		(authr.RemoteUrl != data.RemoteUrl || authr.RemoteCa != data.RemoteCa)
+
 	authr.Name = data.Name
-	authr.Type = data.Type
 	authr.Expire = data.Expire
 	authr.HostExpire = data.HostExpire
 	authr.MatchRoles = data.MatchRoles
 	authr.Roles = data.Roles
 
 	if !authr.HostCertificates && data.HostCertificates {
-		err = authr.TokenNew()
+		_, _, err = authr.TokenNew("", time.Time{}, "")
 		if err != nil {
 			utils.AbortWithError(c, 500, err)
 			return
@@ -70,13 +113,35 @@ func authorityPut(c *gin.Context) {
 	authr.HostProxy = data.HostProxy
 	authr.HostCertificates = data.HostCertificates
 	authr.StrictHostChecking = data.StrictHostChecking
+	authr.RegisteredKeys = data.RegisteredKeys
+	authr.KeyApproval = data.KeyApproval
+	authr.RecordingRequired = data.RecordingRequired
+	authr.HostPrincipals = data.HostPrincipals
+	authr.HostChallengeHttps = data.HostChallengeHttps
+	authr.HostChallengeCa = data.HostChallengeCa
+	authr.Extensions = data.Extensions
+	authr.ForceCommand = data.ForceCommand
+	authr.SourceAddress = data.SourceAddress
+	authr.SourceClientIp = data.SourceClientIp
+	authr.RoleAddresses = data.RoleAddresses
+	authr.PrincipalMappings = data.PrincipalMappings
+	authr.Pkcs11Module = data.Pkcs11Module
+	authr.Pkcs11Token = data.Pkcs11Token
+	authr.Pkcs11Key = data.Pkcs11Key
+	if data.Pkcs11Pin != "" {
+		authr.Pkcs11Pin = data.Pkcs11Pin
+	}
+	authr.RemoteUrl = data.RemoteUrl
+	authr.RemoteCa = data.RemoteCa
+	if data.RemoteToken != "" {
+		authr.RemoteToken = data.RemoteToken
+	}
 
 	fields := set.NewSet(
 		"name",
 		"type",
 		"expire",
 		"host_expire",
-		"info",
 		"match_roles",
 		"roles",
 		"host_domain",
@@ -84,7 +149,30 @@ func authorityPut(c *gin.Context) {
 		"host_proxy",
 		"host_certificates",
 		"strict_host_checking",
+		"registered_keys",
+		"key_approval",
+		"recording_required",
+		"host_principals",
+		"host_challenge_https",
+		"host_challenge_ca",
+		"extensions",
+		"force_command",
+		"source_address",
+		"source_client_ip",
+		"role_addresses",
+		"principal_mappings",
+		"pkcs11_module",
+		"pkcs11_token",
+		"pkcs11_key",
+		"pkcs11_pin",
+		"remote_url",
+		"remote_token",
+		"remote_ca",
 	)
+	if authr.Type != authority.Local {
+		fields.Add("info")
+		fields.Add("public_key")
+	}
 
 	errData, err := authr.Validate(db)
 	if err != nil {
@@ -97,6 +185,14 @@ func authorityPut(c *gin.Context) {
 		return
 	}
 
+	if remoteChanged {
+		errData = authr.VerifyRemote()
+		if errData != nil {
+			c.JSON(400, errData)
+			return
+		}
+	}
+
 	err = authr.CommitFields(db, fields)
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
@@ -133,14 +229,114 @@ func authorityPost(c *gin.Context) {
 		Roles:              data.Roles,
 		HostDomain:         data.HostDomain,
 		StrictHostChecking: data.StrictHostChecking,
+		RegisteredKeys:     data.RegisteredKeys,
+		KeyApproval:        data.KeyApproval,
+		RecordingRequired:  data.RecordingRequired,
+		HostPrincipals:     data.HostPrincipals,
+		HostChallengeHttps: data.HostChallengeHttps,
+		HostChallengeCa:    data.HostChallengeCa,
+		Extensions:         data.Extensions,
+		ForceCommand:       data.ForceCommand,
+		SourceAddress:      data.SourceAddress,
+		SourceClientIp:     data.SourceClientIp,
+		RoleAddresses:      data.RoleAddresses,
+		PrincipalMappings:  data.PrincipalMappings,
+		Pkcs11Module:       data.Pkcs11Module,
+		Pkcs11Token:        data.Pkcs11Token,
+		Pkcs11Key:          data.Pkcs11Key,
+		Pkcs11Pin:          data.Pkcs11Pin,
+		RemoteUrl:          data.RemoteUrl,
+		RemoteToken:        data.RemoteToken,
+		RemoteCa:           data.RemoteCa,
+	}
+
+	if authr.Type == "" || authr.Type == authority.Local {
+		if !authority.ValidKeyAlg(data.KeyAlg) {
+			errData := &errortypes.ErrorData{
+				Error:   "key_alg_invalid",
+				Message: "Key algorithm is invalid",
+			}
+			c.JSON(400, errData)
+			return
+		}
+
+		err = authr.GeneratePrivateKey(data.KeyAlg)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
 	}
 
-	err = authr.GenerateRsaPrivateKey()
+	errData, err := authr.Validate(db)
 	if err != nil {
+		utils.AbortWithError(c, 500, err)
 		return
 	}
 
-	errData, err := authr.Validate(db)
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
+	err = authr.Insert(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "authority.change")
+
+	c.JSON(200, authr)
+}
+
+type authorityImportData struct {
+	PrivateKey string `json:"private_key"`
+	Passphrase string `json:"passphrase"`
+}
+
+func authorityImportPost(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	authzr := c.MustGet("authorizer").(*authorizer.Authorizer)
+	data := &authorityImportData{}
+
+	authrId, ok := utils.ParseObjectId(c.Param("authr_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	err := c.Bind(data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	usr, err := authzr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	authr, err := authority.Get(db, authrId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if authr.Type != authority.Local {
+		errData := &errortypes.ErrorData{
+			Error:   "authority_type_unsupported",
+			Message: "Keys can only be imported to local authorities",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	errData, err := authr.ImportPrivateKey(data.PrivateKey, data.Passphrase)
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
 		return
@@ -151,7 +347,268 @@ func authorityPost(c *gin.Context) {
 		return
 	}
 
-	err = authr.Insert(db)
+	err = authr.CommitFields(db, authority.RotateFields)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = audit.New(
+		db,
+		c.Request,
+		usr.Id,
+		audit.AuthorityKeyImport,
+		audit.Fields{
+			"authority_id": authr.Id,
+			"key_alg":      authr.Info.KeyAlg,
+			"public_key":   authr.PublicKey,
+		},
+	)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "authority.change")
+
+	c.JSON(200, authr)
+}
+
+type authorityRotateData struct {
+	KeyAlg          string    `json:"key_alg"`
+	RotateTimestamp time.Time `json:"rotate_timestamp"`
+}
+
+func authorityRotatePost(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	authzr := c.MustGet("authorizer").(*authorizer.Authorizer)
+	data := &authorityRotateData{}
+
+	authrId, ok := utils.ParseObjectId(c.Param("authr_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	err := c.Bind(data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if !authority.ValidKeyAlg(data.KeyAlg) {
+		errData := &errortypes.ErrorData{
+			Error:   "key_alg_invalid",
+			Message: "Key algorithm is invalid",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	usr, err := authzr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	authr, err := authority.Get(db, authrId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if authr.Type != authority.Local {
+		errData := &errortypes.ErrorData{
+			Error:   "authority_type_unsupported",
+			Message: "Keys can only be rotated on local authorities",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	if authr.RotateState == authority.RotateSwitched {
+		errData := &errortypes.ErrorData{
+			Error:   "rotate_previous_active",
+			Message: "Previous key must be retired before rotating",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	if authr.RotateState == authority.RotateStaged {
+		errData := &errortypes.ErrorData{
+			Error:   "rotate_staged",
+			Message: "Staged key must be switched before rotating again",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	err = authr.RotateStage(data.KeyAlg, data.RotateTimestamp)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = authr.CommitFields(db, authority.RotateFields)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = audit.New(
+		db,
+		c.Request,
+		usr.Id,
+		audit.AuthorityRotateStage,
+		audit.Fields{
+			"authority_id":     authr.Id,
+			"key_alg":          authr.NextInfo.KeyAlg,
+			"next_public_key":  authr.NextPublicKey,
+			"rotate_timestamp": authr.RotateTimestamp,
+		},
+	)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "authority.change")
+
+	c.JSON(200, authr)
+}
+
+func authorityRotatePut(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	authzr := c.MustGet("authorizer").(*authorizer.Authorizer)
+
+	authrId, ok := utils.ParseObjectId(c.Param("authr_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	usr, err := authzr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	authr, err := authority.Get(db, authrId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if authr.RotateState != authority.RotateStaged {
+		errData := &errortypes.ErrorData{
+			Error:   "rotate_not_staged",
+			Message: "No key has been staged for rotation",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	err = authr.RotateSwitch()
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = authr.CommitFields(db, authority.RotateFields)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = audit.New(
+		db,
+		c.Request,
+		usr.Id,
+		audit.AuthorityRotateSwitch,
+		audit.Fields{
+			"authority_id":        authr.Id,
+			"key_alg":             authr.Info.KeyAlg,
+			"public_key":          authr.PublicKey,
+			"previous_public_key": authr.PreviousPublicKey,
+		},
+	)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "authority.change")
+
+	c.JSON(200, authr)
+}
+
+func authorityRotateDelete(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	authzr := c.MustGet("authorizer").(*authorizer.Authorizer)
+
+	authrId, ok := utils.ParseObjectId(c.Param("authr_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	usr, err := authzr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	authr, err := authority.Get(db, authrId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	var typ string
+	var fields audit.Fields
+
+	switch authr.RotateState {
+	case authority.RotateStaged:
+		typ = audit.AuthorityRotateCancel
+		fields = audit.Fields{
+			"authority_id":    authr.Id,
+			"next_public_key": authr.NextPublicKey,
+		}
+		break
+	case authority.RotateSwitched:
+		typ = audit.AuthorityRotateRetire
+		fields = audit.Fields{
+			"authority_id":        authr.Id,
+			"previous_public_key": authr.PreviousPublicKey,
+		}
+		break
+	default:
+		c.JSON(200, authr)
+		return
+	}
+
+	authr.RotateClear()
+
+	err = authr.CommitFields(db, authority.RotateFields)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = audit.New(db, c.Request, usr.Id, typ, fields)
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
 		return
@@ -162,6 +619,77 @@ func authorityPost(c *gin.Context) {
 	c.JSON(200, authr)
 }
 
+type authorityPrincipalsData struct {
+	UserId            bson.ObjectId                 `json:"user_id"`
+	Username          string                        `json:"username"`
+	Roles             []string                      `json:"roles"`
+	PrincipalMappings []*authority.PrincipalMapping `json:"principal_mappings"`
+}
+
+type authorityPrincipalsResp struct {
+	Principals []string `json:"principals"`
+}
+
+func authorityPrincipalsPost(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+	data := &authorityPrincipalsData{}
+
+	authrId, ok := utils.ParseObjectId(c.Param("authr_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	err := c.Bind(data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	authr, err := authority.Get(db, authrId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if data.PrincipalMappings != nil {
+		for _, mapping := range data.PrincipalMappings {
+			errData := authority.ValidatePrincipal(mapping.Principal)
+			if errData != nil {
+				c.JSON(400, errData)
+				return
+			}
+		}
+
+		authr.PrincipalMappings = data.PrincipalMappings
+	}
+
+	var usr *user.User
+	if data.UserId != "" {
+		usr, err = user.Get(db, data.UserId)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+	} else {
+		usr = &user.User{
+			Id:       bson.NewObjectId(),
+			Username: data.Username,
+			Roles:    data.Roles,
+		}
+	}
+
+	if usr.Roles == nil {
+		usr.Roles = []string{}
+	}
+
+	resp := &authorityPrincipalsResp{
+		Principals: authr.GetPrincipals(usr),
+	}
+
+	c.JSON(200, resp)
+}
+
 func authorityDelete(c *gin.Context) {
 	if demo.Blocked(c) {
 		return
@@ -203,7 +731,7 @@ func authorityGet(c *gin.Context) {
 
 	if demo.IsDemo() {
 		for i := range authr.HostTokens {
-			authr.HostTokens[i] = "demo"
+			authr.HostTokens[i].Token = "demo"
 		}
 	}
 
@@ -222,7 +750,7 @@ func authoritysGet(c *gin.Context) {
 	if demo.IsDemo() {
 		for _, authr := range authrs {
 			for i := range authr.HostTokens {
-				authr.HostTokens[i] = "demo"
+				authr.HostTokens[i].Token = "demo"
 			}
 		}
 	}
@@ -263,18 +791,27 @@ func authorityPublicKeyGet(c *gin.Context) {
 	}
 
 	for _, authr := range authrs {
-		publicKeys += strings.TrimSpace(authr.PublicKey) + "\n"
+		for _, publicKey := range authr.GetPublicKeys() {
+			publicKeys += strings.TrimSpace(publicKey) + "\n"
+		}
 	}
 
 	c.String(200, publicKeys)
 }
 
+type authorityTokenData struct {
+	Name    string    `json:"name"`
+	Expires time.Time `json:"expires"`
+	Match   string    `json:"match"`
+}
+
 func authorityTokenPost(c *gin.Context) {
 	if demo.Blocked(c) {
 		return
 	}
 
 	db := c.MustGet("db").(*database.Database)
+	data := &authorityTokenData{}
 
 	authrId, ok := utils.ParseObjectId(c.Param("authr_id"))
 	if !ok {
@@ -282,18 +819,31 @@ func authorityTokenPost(c *gin.Context) {
 		return
 	}
 
+	if c.Request.ContentLength != 0 {
+		err := c.Bind(data)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+	}
+
 	authr, err := authority.Get(db, authrId)
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
 		return
 	}
 
-	err = authr.TokenNew()
+	tokn, errData, err := authr.TokenNew(data.Name, data.Expires, data.Match)
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
 		return
 	}
 
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
 	err = authr.CommitFields(db, set.NewSet("host_tokens"))
 	if err != nil {
 		utils.AbortWithError(c, 500, err)
@@ -302,7 +852,7 @@ func authorityTokenPost(c *gin.Context) {
 
 	event.PublishDispatch(db, "authority.change")
 
-	c.Status(200)
+	c.JSON(200, tokn)
 }
 
 func authorityTokenDelete(c *gin.Context) {
@@ -53,10 +53,17 @@ func Register(engine *gin.Engine) {
 	csrfGroup.PUT("/authority/:authr_id", authorityPut)
 	csrfGroup.POST("/authority", authorityPost)
 	csrfGroup.DELETE("/authority/:authr_id", authorityDelete)
+	csrfGroup.POST("/authority/:authr_id/import", authorityImportPost)
+	csrfGroup.POST("/authority/:authr_id/principals",
+		authorityPrincipalsPost)
+	csrfGroup.POST("/authority/:authr_id/rotate", authorityRotatePost)
+	csrfGroup.PUT("/authority/:authr_id/rotate", authorityRotatePut)
+	csrfGroup.DELETE("/authority/:authr_id/rotate", authorityRotateDelete)
 	csrfGroup.POST("/authority/:authr_id/token", authorityTokenPost)
 	csrfGroup.DELETE("/authority/:authr_id/token/:token",
 		authorityTokenDelete)
 	dbGroup.GET("/ssh_public_key/:authr_ids", authorityPublicKeyGet)
+	dbGroup.GET("/ssh_krl/:authr_id", authorityKrlGet)
 
 	csrfGroup.GET("/certificate", certificatesGet)
 	csrfGroup.GET("/certificate/:cert_id", certificateGet)
@@ -70,6 +77,11 @@ func Register(engine *gin.Engine) {
 
 	csrfGroup.GET("/event", eventGet)
 
+	csrfGroup.GET("/host", hostsGet)
+	csrfGroup.GET("/host/:host_id", hostGet)
+	csrfGroup.PUT("/host/:host_id", hostPut)
+	csrfGroup.DELETE("/host/:host_id", hostDelete)
+
 	csrfGroup.GET("/log", logsGet)
 	csrfGroup.GET("/log/:log_id", logGet)
 
@@ -84,6 +96,11 @@ func Register(engine *gin.Engine) {
 	csrfGroup.POST("/policy", policyPost)
 	csrfGroup.DELETE("/policy/:policy_id", policyDelete)
 
+	csrfGroup.GET("/revocation", revocationsGet)
+	csrfGroup.POST("/revocation", revocationPost)
+	csrfGroup.POST("/revocation/user/:user_id", revocationUserPost)
+	csrfGroup.DELETE("/revocation/:revocation_id", revocationDelete)
+
 	csrfGroup.GET("/service", servicesGet)
 	csrfGroup.PUT("/service/:service_id", servicePut)
 	csrfGroup.POST("/service", servicePost)
@@ -96,6 +113,19 @@ func Register(engine *gin.Engine) {
 	csrfGroup.PUT("/settings", settingsPut)
 
 	csrfGroup.GET("/sshcertificate/:user_id", sshcertsGet)
+	csrfGroup.GET("/sshcertificate_lookup", sshcertLookupGet)
+
+	csrfGroup.GET("/sshkey/:user_id", sshkeysGet)
+	csrfGroup.POST("/sshkey/:user_id", sshkeyPost)
+	csrfGroup.PUT("/sshkey/:user_id/:key_id/approve", sshkeyApprovePut)
+	csrfGroup.DELETE("/sshkey/:user_id/:key_id", sshkeyDelete)
+
+	csrfGroup.GET("/sshrecording", recordingsGet)
+	csrfGroup.GET("/sshrecording/:recording_id", recordingGet)
+	csrfGroup.GET("/sshrecording/:recording_id/download",
+		recordingDownloadGet)
+	csrfGroup.PUT("/sshrecording/:recording_id", recordingPut)
+	csrfGroup.DELETE("/sshrecording/:recording_id", recordingDelete)
 
 	csrfGroup.GET("/subscription", subscriptionGet)
 	csrfGroup.GET("/subscription/update", subscriptionUpdateGet)
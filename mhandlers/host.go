@@ -0,0 +1,188 @@
+package mhandlers
+
+import (
+	"fmt"
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/gin-gonic/gin"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/demo"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/event"
+	"github.com/hillrnate/pritunl-zero/host"
+	"github.com/hillrnate/pritunl-zero/utils"
+	"gopkg.in/mgo.v2/bson"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type hostData struct {
+	Blocked bool `json:"blocked"`
+}
+
+type hostsData struct {
+	Hosts []*host.Host `json:"hosts"`
+	Count int          `json:"count"`
+}
+
+func hostsGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageCount, _ := strconv.Atoi(c.Query("page_count"))
+
+	query := bson.M{}
+
+	hostname := strings.TrimSpace(c.Query("hostname"))
+	if hostname != "" {
+		query["hostname"] = &bson.M{
+			"$regex": fmt.Sprintf(".*%s.*",
+				regexp.QuoteMeta(hostname)),
+			"$options": "i",
+		}
+	}
+
+	authrIdStr := c.Query("authority_id")
+	if authrIdStr != "" {
+		authrId, ok := utils.ParseObjectId(authrIdStr)
+		if !ok {
+			utils.AbortWithStatus(c, 400)
+			return
+		}
+		query["authority_id"] = authrId
+	}
+
+	stale := c.Query("stale")
+	switch stale {
+	case "true":
+		query["expires"] = &bson.M{
+			"$lt": time.Now(),
+		}
+		break
+	case "false":
+		query["expires"] = &bson.M{
+			"$gte": time.Now(),
+		}
+		break
+	}
+
+	blocked := c.Query("blocked")
+	switch blocked {
+	case "true":
+		query["blocked"] = true
+		break
+	case "false":
+		query["blocked"] = false
+		break
+	}
+
+	hosts, count, err := host.GetAll(db, &query, page, pageCount)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	data := &hostsData{
+		Hosts: hosts,
+		Count: count,
+	}
+
+	c.JSON(200, data)
+}
+
+func hostGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+
+	hostId, ok := utils.ParseObjectId(c.Param("host_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	hst, err := host.Get(db, hostId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	c.JSON(200, hst)
+}
+
+func hostPut(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	data := &hostData{}
+
+	hostId, ok := utils.ParseObjectId(c.Param("host_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	err := c.Bind(data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	hst, err := host.Get(db, hostId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	hst.Blocked = data.Blocked
+
+	err = hst.CommitFields(db, set.NewSet("blocked"))
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "host.change")
+
+	c.JSON(200, hst)
+}
+
+func hostDelete(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+
+	hostId, ok := utils.ParseObjectId(c.Param("host_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	hst, err := host.Get(db, hostId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if hst.Blocked {
+		errData := &errortypes.ErrorData{
+			Error:   "host_blocked",
+			Message: "Blocked hosts must be unblocked before removing",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	err = host.Remove(db, hst.Id)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "host.change")
+
+	c.JSON(200, nil)
+}
@@ -22,6 +22,8 @@ type nodeData struct {
 	UserDomain         string          `json:"user_domain"`
 	Services           []bson.ObjectId `json:"services"`
 	ForwardedForHeader string          `json:"forwarded_for_header"`
+	BastionPort        int             `json:"bastion_port"`
+	BastionAuthorities []bson.ObjectId `json:"bastion_authorities"`
 }
 
 func nodePut(c *gin.Context) {
@@ -59,6 +61,8 @@ func nodePut(c *gin.Context) {
 	nde.UserDomain = data.UserDomain
 	nde.Services = data.Services
 	nde.ForwardedForHeader = data.ForwardedForHeader
+	nde.BastionPort = data.BastionPort
+	nde.BastionAuthorities = data.BastionAuthorities
 
 	fields := set.NewSet(
 		"name",
@@ -70,6 +74,8 @@ func nodePut(c *gin.Context) {
 		"user_domain",
 		"services",
 		"forwarded_for_header",
+		"bastion_port",
+		"bastion_authorities",
 	)
 
 	errData, err := nde.Validate(db)
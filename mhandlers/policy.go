@@ -23,6 +23,7 @@ type policyData struct {
 	UserSecondary      bson.ObjectId           `json:"user_secondary"`
 	ProxySecondary     bson.ObjectId           `json:"proxy_secondary"`
 	AuthoritySecondary bson.ObjectId           `json:"authority_secondary"`
+	AuthorityExpire    int                     `json:"authority_expire"`
 }
 
 func policyPut(c *gin.Context) {
@@ -61,6 +62,7 @@ func policyPut(c *gin.Context) {
 	polcy.UserSecondary = data.UserSecondary
 	polcy.ProxySecondary = data.ProxySecondary
 	polcy.AuthoritySecondary = data.AuthoritySecondary
+	polcy.AuthorityExpire = data.AuthorityExpire
 
 	fields := set.NewSet(
 		"name",
@@ -73,6 +75,7 @@ func policyPut(c *gin.Context) {
 		"user_secondary",
 		"proxy_secondary",
 		"authority_secondary",
+		"authority_expire",
 	)
 
 	errData, err := polcy.Validate(db)
@@ -124,6 +127,7 @@ func policyPost(c *gin.Context) {
 		UserSecondary:      data.UserSecondary,
 		ProxySecondary:     data.ProxySecondary,
 		AuthoritySecondary: data.AuthoritySecondary,
+		AuthorityExpire:    data.AuthorityExpire,
 	}
 
 	errData, err := polcy.Validate(db)
@@ -0,0 +1,295 @@
+package mhandlers
+
+import (
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/gin-gonic/gin"
+	"github.com/hillrnate/pritunl-zero/audit"
+	"github.com/hillrnate/pritunl-zero/authorizer"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/demo"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/event"
+	"github.com/hillrnate/pritunl-zero/recording"
+	"github.com/hillrnate/pritunl-zero/utils"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type recordingData struct {
+	Hold bool `json:"hold"`
+}
+
+type recordingsData struct {
+	Recordings []*recording.Recording `json:"recordings"`
+	Count      int                    `json:"count"`
+}
+
+func recordingsGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageCount, _ := strconv.Atoi(c.Query("page_count"))
+
+	userId := bson.ObjectId("")
+	if c.Query("user_id") != "" {
+		id, ok := utils.ParseObjectId(c.Query("user_id"))
+		if !ok {
+			utils.AbortWithStatus(c, 400)
+			return
+		}
+		userId = id
+	}
+
+	serial := strings.TrimSpace(c.Query("serial"))
+	hostname := strings.TrimSpace(c.Query("host"))
+
+	recs, count, err := recording.GetAll(
+		db, userId, serial, hostname, page, pageCount)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	data := &recordingsData{
+		Recordings: recs,
+		Count:      count,
+	}
+
+	c.JSON(200, data)
+}
+
+func recordingGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+
+	recId, ok := utils.ParseObjectId(c.Param("recording_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	rec, err := recording.Get(db, recId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	c.JSON(200, rec)
+}
+
+func recordingDownloadGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+	authzr := c.MustGet("authorizer").(*authorizer.Authorizer)
+
+	recId, ok := utils.ParseObjectId(c.Param("recording_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	adminUsr, err := authzr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	rec, err := recording.Get(db, recId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if !rec.Accessible() {
+		errData := &errortypes.ErrorData{
+			Error: "recording_unavailable",
+			Message: "Recording is stored on the disk of another " +
+				"management node",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	reader, err := rec.Open(db)
+	if err != nil {
+		switch err.(type) {
+		case *database.NotFoundError, *errortypes.NotFoundError:
+			utils.AbortWithStatus(c, 404)
+			break
+		default:
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+	defer reader.Close()
+
+	err = audit.New(
+		db,
+		c.Request,
+		rec.UserId,
+		audit.SshRecordingDownload,
+		audit.Fields{
+			"recording_id": rec.Id,
+			"serial":       rec.Serial,
+			"host":         rec.Host,
+			"admin_id":     adminUsr.Id,
+		},
+	)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	c.Header("Content-Type", "application/x-asciicast")
+	c.Header("Content-Disposition",
+		"attachment; filename=\""+rec.Id.Hex()+".cast\"")
+	c.Status(200)
+
+	io.Copy(c.Writer, reader)
+}
+
+func recordingPut(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	authzr := c.MustGet("authorizer").(*authorizer.Authorizer)
+	data := &recordingData{}
+
+	recId, ok := utils.ParseObjectId(c.Param("recording_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	err := c.Bind(data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	adminUsr, err := authzr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	rec, err := recording.Get(db, recId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if rec.Hold != data.Hold {
+		rec.Hold = data.Hold
+
+		err = rec.CommitFields(db, set.NewSet("hold"))
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		err = audit.New(
+			db,
+			c.Request,
+			rec.UserId,
+			audit.SshRecordingHold,
+			audit.Fields{
+				"recording_id": rec.Id,
+				"hold":         rec.Hold,
+				"admin_id":     adminUsr.Id,
+			},
+		)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		event.PublishDispatch(db, "recording.change")
+	}
+
+	c.JSON(200, rec)
+}
+
+func recordingDelete(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	authzr := c.MustGet("authorizer").(*authorizer.Authorizer)
+
+	recId, ok := utils.ParseObjectId(c.Param("recording_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	adminUsr, err := authzr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	rec, err := recording.Get(db, recId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if rec.Hold {
+		errData := &errortypes.ErrorData{
+			Error:   "recording_hold",
+			Message: "Recording is on hold and cannot be removed",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	if rec.Active {
+		errData := &errortypes.ErrorData{
+			Error:   "recording_active",
+			Message: "Recording is active and cannot be removed",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	if !rec.Accessible() {
+		errData := &errortypes.ErrorData{
+			Error: "recording_unavailable",
+			Message: "Recording is stored on the disk of another " +
+				"management node",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	err = recording.Remove(db, rec)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = audit.New(
+		db,
+		c.Request,
+		rec.UserId,
+		audit.SshRecordingRemove,
+		audit.Fields{
+			"recording_id": rec.Id,
+			"serial":       rec.Serial,
+			"host":         rec.Host,
+			"admin_id":     adminUsr.Id,
+		},
+	)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "recording.change")
+
+	c.JSON(200, nil)
+}
@@ -0,0 +1,186 @@
+package mhandlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/hillrnate/pritunl-zero/authority"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/demo"
+	"github.com/hillrnate/pritunl-zero/event"
+	"github.com/hillrnate/pritunl-zero/revocation"
+	"github.com/hillrnate/pritunl-zero/utils"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type revocationData struct {
+	AuthorityId bson.ObjectId `json:"authority_id"`
+	Type        string        `json:"type"`
+	Value       string        `json:"value"`
+	Comment     string        `json:"comment"`
+}
+
+func revocationsGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+
+	query := bson.M{}
+
+	authrIdStr := c.Query("authority_id")
+	if authrIdStr != "" {
+		authrId, ok := utils.ParseObjectId(authrIdStr)
+		if !ok {
+			utils.AbortWithStatus(c, 400)
+			return
+		}
+		query["authority_id"] = authrId
+	}
+
+	userIdStr := c.Query("user_id")
+	if userIdStr != "" {
+		userId, ok := utils.ParseObjectId(userIdStr)
+		if !ok {
+			utils.AbortWithStatus(c, 400)
+			return
+		}
+		query["user_id"] = userId
+	}
+
+	revcs, err := revocation.GetAll(db, &query)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	c.JSON(200, revcs)
+}
+
+func revocationPost(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	data := &revocationData{}
+
+	err := c.Bind(data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	revc := &revocation.Revocation{
+		AuthorityId: data.AuthorityId,
+		Type:        data.Type,
+		Value:       data.Value,
+		Comment:     data.Comment,
+	}
+
+	errData, err := revc.Validate(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
+	err = revc.Insert(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "revocation.change")
+
+	c.JSON(200, revc)
+}
+
+func revocationUserPost(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+
+	userId, ok := utils.ParseObjectId(c.Param("user_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	err := revocation.RevokeUser(db, userId, "User revoked")
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "revocation.change")
+
+	c.JSON(200, nil)
+}
+
+func revocationDelete(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+
+	revcId, ok := utils.ParseObjectId(c.Param("revocation_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	err := revocation.Remove(db, revcId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "revocation.change")
+
+	c.JSON(200, nil)
+}
+
+func authorityKrlGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+
+	authrId, ok := utils.ParseObjectId(c.Param("authr_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	authr, err := authority.Get(db, authrId)
+	if err != nil {
+		switch err.(type) {
+		case *database.NotFoundError:
+			utils.AbortWithStatus(c, 404)
+			break
+		default:
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+
+	revcs, err := revocation.GetAuthority(db, authr.Id)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	version, err := revocation.GetVersion(db, authr.Id)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	krl, err := revocation.GenerateKrl(authr, version, revcs)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	c.Data(200, "application/octet-stream", krl)
+}
@@ -4,9 +4,13 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/hillrnate/pritunl-zero/database"
 	"github.com/hillrnate/pritunl-zero/demo"
+	"github.com/hillrnate/pritunl-zero/errortypes"
 	"github.com/hillrnate/pritunl-zero/ssh"
+	"github.com/hillrnate/pritunl-zero/user"
 	"github.com/hillrnate/pritunl-zero/utils"
+	"gopkg.in/mgo.v2/bson"
 	"strconv"
+	"strings"
 )
 
 type sshcertsData struct {
@@ -49,3 +53,81 @@ func sshcertsGet(c *gin.Context) {
 
 	c.JSON(200, data)
 }
+
+type sshcertLookupData struct {
+	*ssh.Certificate
+	Username string `json:"username"`
+}
+
+func sshcertLookupGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+
+	serial := strings.TrimSpace(c.Query("serial"))
+	fingerprint := strings.TrimSpace(c.Query("fingerprint"))
+
+	if serial == "" && fingerprint == "" {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	authrId := bson.ObjectId("")
+	if c.Query("authority_id") != "" {
+		id, ok := utils.ParseObjectId(c.Query("authority_id"))
+		if !ok {
+			utils.AbortWithStatus(c, 400)
+			return
+		}
+		authrId = id
+	}
+
+	if serial != "" && authrId == "" {
+		errData := &errortypes.ErrorData{
+			Error:   "authority_required",
+			Message: "Authority must be set for serial lookups",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	if fingerprint != "" && !strings.HasPrefix(fingerprint, "SHA256:") {
+		fingerprint = "SHA256:" + fingerprint
+	}
+
+	certs, err := ssh.LookupCertificates(
+		db, authrId, serial, fingerprint)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	usernames := map[bson.ObjectId]string{}
+	data := []*sshcertLookupData{}
+
+	for _, cert := range certs {
+		username := ""
+
+		if cert.UserId != "" {
+			name, ok := usernames[cert.UserId]
+			if !ok {
+				usr, e := user.Get(db, cert.UserId)
+				if e != nil {
+					if _, ok := e.(*database.NotFoundError); !ok {
+						utils.AbortWithError(c, 500, e)
+						return
+					}
+				} else {
+					name = usr.Username
+				}
+				usernames[cert.UserId] = name
+			}
+			username = name
+		}
+
+		data = append(data, &sshcertLookupData{
+			Certificate: cert,
+			Username:    username,
+		})
+	}
+
+	c.JSON(200, data)
+}
@@ -0,0 +1,245 @@
+package mhandlers
+
+import (
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/gin-gonic/gin"
+	"github.com/hillrnate/pritunl-zero/audit"
+	"github.com/hillrnate/pritunl-zero/authorizer"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/demo"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/event"
+	"github.com/hillrnate/pritunl-zero/sshkey"
+	"github.com/hillrnate/pritunl-zero/user"
+	"github.com/hillrnate/pritunl-zero/utils"
+)
+
+type sshkeyData struct {
+	Label     string `json:"label"`
+	PublicKey string `json:"public_key"`
+}
+
+func sshkeysGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+
+	userId, ok := utils.ParseObjectId(c.Param("user_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	keys, err := sshkey.GetAll(db, userId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	c.JSON(200, keys)
+}
+
+func sshkeyPost(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	authzr := c.MustGet("authorizer").(*authorizer.Authorizer)
+	data := &sshkeyData{}
+
+	userId, ok := utils.ParseObjectId(c.Param("user_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	err := c.Bind(data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	adminUsr, err := authzr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	usr, err := user.Get(db, userId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	key := sshkey.New(usr.Id, data.Label, data.PublicKey, sshkey.Admin)
+
+	errData, err := key.Validate(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
+	err = key.Insert(db)
+	if err != nil {
+		if _, ok := err.(*database.DuplicateKeyError); ok {
+			errData := &errortypes.ErrorData{
+				Error:   "ssh_key_exists",
+				Message: "SSH key is already registered",
+			}
+			c.JSON(400, errData)
+		} else {
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+
+	err = audit.New(
+		db,
+		c.Request,
+		usr.Id,
+		audit.SshKeyRegister,
+		audit.Fields{
+			"key_id":      key.Id,
+			"fingerprint": key.Fingerprint,
+			"key_type":    key.KeyType,
+			"source":      key.Source,
+			"admin_id":    adminUsr.Id,
+		},
+	)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "sshkey.change")
+
+	c.JSON(200, key)
+}
+
+func sshkeyApprovePut(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	authzr := c.MustGet("authorizer").(*authorizer.Authorizer)
+
+	userId, ok := utils.ParseObjectId(c.Param("user_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	keyId, ok := utils.ParseObjectId(c.Param("key_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	adminUsr, err := authzr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	key, err := sshkey.Get(db, userId, keyId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if !key.Unapproved {
+		c.JSON(200, key)
+		return
+	}
+
+	key.Unapproved = false
+	err = key.CommitFields(db, set.NewSet("unapproved"))
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = audit.New(
+		db,
+		c.Request,
+		userId,
+		audit.SshKeyApprove,
+		audit.Fields{
+			"key_id":      key.Id,
+			"fingerprint": key.Fingerprint,
+			"admin_id":    adminUsr.Id,
+		},
+	)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "sshkey.change")
+
+	c.JSON(200, key)
+}
+
+func sshkeyDelete(c *gin.Context) {
+	if demo.Blocked(c) {
+		return
+	}
+
+	db := c.MustGet("db").(*database.Database)
+	authzr := c.MustGet("authorizer").(*authorizer.Authorizer)
+
+	userId, ok := utils.ParseObjectId(c.Param("user_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	keyId, ok := utils.ParseObjectId(c.Param("key_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	adminUsr, err := authzr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	key, err := sshkey.Get(db, userId, keyId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = sshkey.Remove(db, userId, keyId)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = audit.New(
+		db,
+		c.Request,
+		userId,
+		audit.SshKeyRemove,
+		audit.Fields{
+			"key_id":      key.Id,
+			"fingerprint": key.Fingerprint,
+			"admin_id":    adminUsr.Id,
+		},
+	)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "sshkey.change")
+
+	c.JSON(200, nil)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/hillrnate/pritunl-zero/database"
 	"github.com/hillrnate/pritunl-zero/demo"
 	"github.com/hillrnate/pritunl-zero/event"
+	"github.com/hillrnate/pritunl-zero/revocation"
 	"github.com/hillrnate/pritunl-zero/user"
 	"github.com/hillrnate/pritunl-zero/utils"
 	"gopkg.in/mgo.v2/bson"
@@ -88,6 +89,8 @@ func userPut(c *gin.Context) {
 		return
 	}
 
+	wasDisabled := usr.Disabled
+
 	showSecret := false
 	if usr.Type != data.Type {
 		if data.Type == user.Api {
@@ -160,6 +163,16 @@ func userPut(c *gin.Context) {
 		return
 	}
 
+	if usr.Disabled && !wasDisabled {
+		err = revocation.RevokeUser(db, usr.Id, "User disabled")
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		event.PublishDispatch(db, "revocation.change")
+	}
+
 	event.PublishDispatch(db, "user.change")
 
 	if !showSecret {
@@ -339,6 +352,16 @@ func usersDelete(c *gin.Context) {
 		return
 	}
 
+	for _, userId := range data {
+		err = revocation.RevokeUser(db, userId, "User removed")
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+	}
+
+	event.PublishDispatch(db, "revocation.change")
+
 	event.PublishDispatch(db, "user.change")
 
 	c.JSON(200, nil)
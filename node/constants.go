@@ -4,4 +4,7 @@ const (
 	Management = "management"
 	User       = "user"
 	Proxy      = "proxy"
+	Bastion    = "bastion"
 )
+
+const DefaultBastionPort = 2222
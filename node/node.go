@@ -37,6 +37,10 @@ type Node struct {
 	Services           []bson.ObjectId            `bson:"services" json:"services"`
 	RequestsMin        int64                      `bson:"requests_min" json:"requests_min"`
 	ForwardedForHeader string                     `bson:"forwarded_for_header" json:"forwarded_for_header"`
+	BastionPort        int                        `bson:"bastion_port" json:"bastion_port"`
+	BastionAuthorities []bson.ObjectId            `bson:"bastion_authorities" json:"bastion_authorities"`
+	BastionHostKey     string                     `bson:"bastion_host_key" json:"-"`
+	BastionPublicKey   string                     `bson:"bastion_public_key" json:"bastion_public_key"`
 	Memory             float64                    `bson:"memory" json:"memory"`
 	Load1              float64                    `bson:"load1" json:"load1"`
 	Load5              float64                    `bson:"load5" json:"load5"`
@@ -85,14 +89,15 @@ func (n *Node) Validate(db *database.Database) (
 		n.Type = Management
 	}
 
-	if n.Type == Management {
+	webType := n.GetWebType()
+	if webType == Management {
 		n.ManagementDomain = ""
 		n.UserDomain = ""
 	} else {
-		if !strings.Contains(n.Type, Management) {
+		if !strings.Contains(webType, Management) {
 			n.ManagementDomain = ""
 		}
-		if !strings.Contains(n.Type, User) {
+		if !strings.Contains(webType, User) {
 			n.UserDomain = ""
 		}
 	}
@@ -101,6 +106,26 @@ func (n *Node) Validate(db *database.Database) (
 		n.Services = []bson.ObjectId{}
 	}
 
+	if n.BastionAuthorities == nil || !strings.Contains(n.Type, Bastion) {
+		n.BastionAuthorities = []bson.ObjectId{}
+	}
+
+	if n.BastionPort == 0 {
+		n.BastionPort = DefaultBastionPort
+	}
+
+	if strings.Contains(n.Type, Bastion) {
+		if n.BastionPort < 1 || n.BastionPort > 65535 ||
+			n.BastionPort == n.Port {
+
+			errData = &errortypes.ErrorData{
+				Error:   "node_bastion_port_invalid",
+				Message: "Invalid node bastion port",
+			}
+			return
+		}
+	}
+
 	n.Format()
 
 	return
@@ -109,6 +134,19 @@ func (n *Node) Validate(db *database.Database) (
 func (n *Node) Format() {
 	utils.SortObjectIds(n.Services)
 	utils.SortObjectIds(n.Certificates)
+	utils.SortObjectIds(n.BastionAuthorities)
+}
+
+func (n *Node) GetWebType() string {
+	types := []string{}
+
+	for _, typ := range strings.Split(n.Type, "_") {
+		if typ != Bastion {
+			types = append(types, typ)
+		}
+	}
+
+	return strings.Join(types, "_")
 }
 
 func (n *Node) SetActive() {
@@ -195,6 +233,8 @@ func (n *Node) update(db *database.Database) (err error) {
 	n.UserDomain = nde.UserDomain
 	n.Services = nde.Services
 	n.ForwardedForHeader = nde.ForwardedForHeader
+	n.BastionPort = nde.BastionPort
+	n.BastionAuthorities = nde.BastionAuthorities
 
 	return
 }
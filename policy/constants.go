@@ -1,5 +1,10 @@
 package policy
 
+import (
+	"github.com/dropbox/godropbox/container/set"
+	"golang.org/x/crypto/ssh"
+)
+
 const (
 	Optional        = "optional"
 	Required        = "required"
@@ -7,4 +12,19 @@ const (
 	OperatingSystem = "operating_system"
 	Browser         = "browser"
 	Location        = "location"
+	SshExtensions   = "ssh_extensions"
+	SshAccounts     = "ssh_accounts"
+	SshKeyTypes     = "ssh_key_types"
+	SshHosts        = "ssh_hosts"
+)
+
+var keyTypes = set.NewSet(
+	ssh.KeyAlgoRSA,
+	ssh.KeyAlgoDSA,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoED25519,
+	"sk-ecdsa-sha2-nistp256@openssh.com",
+	"sk-ssh-ed25519@openssh.com",
 )
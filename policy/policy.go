@@ -31,6 +31,7 @@ type Policy struct {
 	UserSecondary      bson.ObjectId    `bson:"user_secondary,omitempty" json:"user_secondary"`
 	ProxySecondary     bson.ObjectId    `bson:"proxy_secondary,omitempty" json:"proxy_secondary"`
 	AuthoritySecondary bson.ObjectId    `bson:"authority_secondary,omitempty" json:"authority_secondary"`
+	AuthorityExpire    int              `bson:"authority_expire" json:"authority_expire"`
 }
 
 func (p *Policy) Validate(db *database.Database) (
@@ -49,6 +50,28 @@ func (p *Policy) Validate(db *database.Database) (
 		}
 	}
 
+	for _, rule := range p.Rules {
+		if rule.Type != SshKeyTypes {
+			continue
+		}
+
+		for _, value := range rule.Values {
+			if !ValidKeyType(value) {
+				errData = &errortypes.ErrorData{
+					Error:   "ssh_key_type_invalid",
+					Message: "SSH key type policy is invalid",
+				}
+				return
+			}
+		}
+	}
+
+	if p.AuthorityExpire < 0 {
+		p.AuthorityExpire = 0
+	} else if p.AuthorityExpire > 1440 {
+		p.AuthorityExpire = 1440
+	}
+
 	if p.Services == nil {
 		p.Services = []bson.ObjectId{}
 	}
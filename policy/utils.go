@@ -1,8 +1,16 @@
 package policy
 
 import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"github.com/dropbox/godropbox/container/set"
 	"github.com/hillrnate/pritunl-zero/database"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
 	"gopkg.in/mgo.v2/bson"
+	"strconv"
+	"strings"
 )
 
 func Get(db *database.Database, policyId bson.ObjectId) (
@@ -160,3 +168,218 @@ func KeybaseMode(policies []*Policy) (mode string) {
 
 	return
 }
+
+func (p *Policy) HasAuthority(authrId bson.ObjectId) bool {
+	if len(p.Authorities) == 0 {
+		return true
+	}
+
+	for _, polcyAuthrId := range p.Authorities {
+		if polcyAuthrId == authrId {
+			return true
+		}
+	}
+
+	return false
+}
+
+func Extensions(policies []*Policy, authrId bson.ObjectId,
+	extensions []string) (exts []string) {
+
+	exts = extensions
+
+	for _, polcy := range policies {
+		if !polcy.HasAuthority(authrId) {
+			continue
+		}
+
+		for _, rule := range polcy.Rules {
+			if rule.Type != SshExtensions {
+				continue
+			}
+
+			allowed := set.NewSet()
+			for _, value := range rule.Values {
+				allowed.Add(value)
+			}
+
+			narrowed := []string{}
+			for _, ext := range exts {
+				if allowed.Contains(ext) {
+					narrowed = append(narrowed, ext)
+				}
+			}
+			exts = narrowed
+		}
+	}
+
+	return
+}
+
+func AccountAllowed(policies []*Policy, authrId bson.ObjectId,
+	account string) bool {
+
+	for _, polcy := range policies {
+		if !polcy.HasAuthority(authrId) {
+			continue
+		}
+
+		for _, rule := range polcy.Rules {
+			if rule.Type != SshAccounts {
+				continue
+			}
+
+			match := false
+			for _, value := range rule.Values {
+				if value == account {
+					match = true
+					break
+				}
+			}
+
+			if !match {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func HostAllowed(policies []*Policy, authrId bson.ObjectId,
+	hostname string) bool {
+
+	hostname = strings.ToLower(hostname)
+
+	for _, polcy := range policies {
+		if !polcy.HasAuthority(authrId) {
+			continue
+		}
+
+		for _, rule := range polcy.Rules {
+			if rule.Type != SshHosts {
+				continue
+			}
+
+			match := false
+			for _, value := range rule.Values {
+				value = strings.ToLower(value)
+
+				if strings.HasPrefix(value, "*.") {
+					if strings.HasSuffix(hostname, value[1:]) {
+						match = true
+						break
+					}
+				} else if value == hostname {
+					match = true
+					break
+				}
+			}
+
+			if !match {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func keySize(pubKey ssh.PublicKey) int {
+	cryptoKey, ok := pubKey.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+
+	switch key := cryptoKey.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return key.N.BitLen()
+	case *dsa.PublicKey:
+		return key.P.BitLen()
+	case *ecdsa.PublicKey:
+		return key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
+	default:
+		return 0
+	}
+}
+
+func parseKeyType(value string) (keyType string, minSize int, valid bool) {
+	parts := strings.SplitN(value, ":", 2)
+
+	keyType = parts[0]
+	if !keyTypes.Contains(keyType) {
+		return
+	}
+
+	if len(parts) == 2 {
+		size, err := strconv.Atoi(parts[1])
+		if err != nil || size <= 0 {
+			return
+		}
+		minSize = size
+	}
+
+	valid = true
+	return
+}
+
+func ValidKeyType(value string) bool {
+	_, _, valid := parseKeyType(value)
+	return valid
+}
+
+func KeyAllowed(policies []*Policy, authrId bson.ObjectId,
+	pubKey ssh.PublicKey) bool {
+
+	pubKeyType := pubKey.Type()
+	pubKeySize := keySize(pubKey)
+
+	for _, polcy := range policies {
+		if !polcy.HasAuthority(authrId) {
+			continue
+		}
+
+		for _, rule := range polcy.Rules {
+			if rule.Type != SshKeyTypes {
+				continue
+			}
+
+			match := false
+			for _, value := range rule.Values {
+				keyType, minSize, valid := parseKeyType(value)
+				if !valid || keyType != pubKeyType {
+					continue
+				}
+
+				if pubKeySize >= minSize {
+					match = true
+					break
+				}
+			}
+
+			if !match {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func AuthorityExpire(policies []*Policy, authrId bson.ObjectId) (
+	expire int) {
+
+	for _, polcy := range policies {
+		if polcy.AuthorityExpire <= 0 || !polcy.HasAuthority(authrId) {
+			continue
+		}
+
+		if expire == 0 || polcy.AuthorityExpire < expire {
+			expire = polcy.AuthorityExpire
+		}
+	}
+
+	return
+}
@@ -0,0 +1,10 @@
+package recording
+
+const (
+	Disk   = "disk"
+	GridFs = "gridfs"
+
+	Input  = "i"
+	Output = "o"
+	Resize = "r"
+)
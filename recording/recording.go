@@ -0,0 +1,154 @@
+package recording
+
+import (
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/node"
+	"github.com/hillrnate/pritunl-zero/utils"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"os"
+	"time"
+)
+
+type Recording struct {
+	Id          bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	NodeId      bson.ObjectId `bson:"node_id" json:"node_id"`
+	UserId      bson.ObjectId `bson:"user_id" json:"user_id"`
+	AuthorityId bson.ObjectId `bson:"authority_id" json:"authority_id"`
+	Serial      string        `bson:"serial" json:"serial"`
+	Principal   string        `bson:"principal" json:"principal"`
+	Host        string        `bson:"host" json:"host"`
+	Port        int           `bson:"port" json:"port"`
+	Command     string        `bson:"command" json:"command"`
+	RemoteIp    string        `bson:"remote_ip" json:"remote_ip"`
+	Storage     string        `bson:"storage" json:"storage"`
+	Path        string        `bson:"path" json:"-"`
+	FileId      bson.ObjectId `bson:"file_id,omitempty" json:"-"`
+	Size        int64         `bson:"size" json:"size"`
+	Active      bool          `bson:"active" json:"active"`
+	Hold        bool          `bson:"hold" json:"hold"`
+	Timestamp   time.Time     `bson:"timestamp" json:"timestamp"`
+	End         time.Time     `bson:"end" json:"end"`
+}
+
+func (r *Recording) Accessible() bool {
+	return r.Storage == GridFs || r.NodeId == node.Self.Id
+}
+
+func (r *Recording) Open(db *database.Database) (
+	reader io.ReadCloser, err error) {
+
+	if r.Storage == GridFs {
+		gfs := db.SshRecordingsGridFs()
+
+		file, e := gfs.OpenId(r.FileId)
+		if e != nil {
+			err = database.ParseError(e)
+			return
+		}
+
+		reader = file
+		return
+	}
+
+	if !r.Accessible() {
+		err = &errortypes.ReadError{
+			errors.New("recording: Recording stored on another node"),
+		}
+		return
+	}
+
+	file, err := os.Open(r.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = &errortypes.NotFoundError{
+				errors.Wrap(err, "recording: Recording file not found"),
+			}
+		} else {
+			err = &errortypes.ReadError{
+				errors.Wrap(err, "recording: Failed to open recording"),
+			}
+		}
+		return
+	}
+
+	reader = file
+	return
+}
+
+func (r *Recording) removeData(db *database.Database) (err error) {
+	if r.Storage == GridFs {
+		if r.FileId == "" {
+			return
+		}
+
+		gfs := db.SshRecordingsGridFs()
+
+		err = gfs.RemoveId(r.FileId)
+		if err != nil {
+			err = database.IgnoreNotFoundError(database.ParseError(err))
+			if err != nil {
+				return
+			}
+		}
+
+		return
+	}
+
+	if r.Path == "" {
+		return
+	}
+
+	if !r.Accessible() {
+		err = &errortypes.WriteError{
+			errors.New("recording: Recording stored on another node"),
+		}
+		return
+	}
+
+	err = utils.ExistsRemove(r.Path)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (r *Recording) Commit(db *database.Database) (err error) {
+	coll := db.SshRecordings()
+
+	err = coll.Commit(r.Id, r)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (r *Recording) CommitFields(db *database.Database, fields set.Set) (
+	err error) {
+
+	coll := db.SshRecordings()
+
+	err = coll.CommitFields(r.Id, r, fields)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (r *Recording) Insert(db *database.Database) (err error) {
+	coll := db.SshRecordings()
+
+	err = coll.Insert(r)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
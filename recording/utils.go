@@ -0,0 +1,156 @@
+package recording
+
+import (
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/node"
+	"github.com/hillrnate/pritunl-zero/settings"
+	"github.com/hillrnate/pritunl-zero/utils"
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+func splitUtf8(data []byte) (valid, rest []byte) {
+	for i := 1; i <= utf8.UTFMax && i <= len(data); i++ {
+		start := len(data) - i
+		if !utf8.RuneStart(data[start]) {
+			continue
+		}
+
+		if !utf8.FullRune(data[start:]) {
+			valid = data[:start]
+			rest = data[start:]
+			return
+		}
+		break
+	}
+
+	valid = data
+	return
+}
+
+func Get(db *database.Database, recId bson.ObjectId) (
+	rec *Recording, err error) {
+
+	coll := db.SshRecordings()
+	rec = &Recording{}
+
+	err = coll.FindOneId(recId, rec)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func GetAll(db *database.Database, userId bson.ObjectId, serial,
+	hostname string, page, pageCount int) (recs []*Recording, count int,
+	err error) {
+
+	coll := db.SshRecordings()
+	recs = []*Recording{}
+
+	query := bson.M{}
+	if userId != "" {
+		query["user_id"] = userId
+	}
+	if serial != "" {
+		query["serial"] = serial
+	}
+	if hostname != "" {
+		query["host"] = strings.ToLower(hostname)
+	}
+
+	qury := coll.Find(query)
+
+	count, err = qury.Count()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	skip := utils.Min(page*pageCount, utils.Max(0, count-pageCount))
+
+	cursor := qury.Sort("-timestamp").Skip(skip).Limit(pageCount).Iter()
+
+	rec := &Recording{}
+	for cursor.Next(rec) {
+		recs = append(recs, rec)
+		rec = &Recording{}
+	}
+
+	err = cursor.Close()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func Remove(db *database.Database, rec *Recording) (err error) {
+	coll := db.SshRecordings()
+
+	err = rec.removeData(db)
+	if err != nil {
+		return
+	}
+
+	_, err = coll.RemoveAll(&bson.M{
+		"_id": rec.Id,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func RemoveExpired(db *database.Database) (count int, err error) {
+	expire := settings.System.SshRecordingExpire
+	if expire <= 0 {
+		return
+	}
+
+	coll := db.SshRecordings()
+
+	cursor := coll.Find(&bson.M{
+		"timestamp": &bson.M{
+			"$lt": time.Now().Add(-time.Duration(expire) * 24 * time.Hour),
+		},
+		"hold": false,
+		"$or": []*bson.M{
+			&bson.M{
+				"storage": GridFs,
+			},
+			&bson.M{
+				"node_id": node.Self.Id,
+			},
+		},
+	}).Iter()
+
+	recs := []*Recording{}
+	rec := &Recording{}
+	for cursor.Next(rec) {
+		recs = append(recs, rec)
+		rec = &Recording{}
+	}
+
+	err = cursor.Close()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	for _, rec := range recs {
+		err = Remove(db, rec)
+		if err != nil {
+			return
+		}
+		count += 1
+	}
+
+	return
+}
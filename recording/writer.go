@@ -0,0 +1,319 @@
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/constants"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/node"
+	"github.com/hillrnate/pritunl-zero/settings"
+	"github.com/hillrnate/pritunl-zero/utils"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+type Writer struct {
+	Recording *Recording
+	file      *os.File
+	start     time.Time
+	size      int64
+	pending   map[string][]byte
+	lock      sync.Mutex
+}
+
+type stream struct {
+	writer *Writer
+	typ    string
+}
+
+func (s *stream) Write(data []byte) (n int, err error) {
+	err = s.writer.write(s.typ, data)
+	if err != nil {
+		return
+	}
+
+	n = len(data)
+	return
+}
+
+func (w *Writer) writeLine(val interface{}) (err error) {
+	line, err := json.Marshal(val)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "recording: Failed to marshal event"),
+		}
+		return
+	}
+
+	n, err := w.file.Write(append(line, '\n'))
+	w.size += int64(n)
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "recording: Failed to write recording"),
+		}
+		return
+	}
+
+	return
+}
+
+func (w *Writer) event(typ, data string) (err error) {
+	elapsed := utils.ToFixed(time.Since(w.start).Seconds(), 6)
+
+	err = w.writeLine([]interface{}{elapsed, typ, data})
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (w *Writer) write(typ string, data []byte) (err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil {
+		err = &errortypes.WriteError{
+			errors.New("recording: Recording is closed"),
+		}
+		return
+	}
+
+	if pending := w.pending[typ]; len(pending) != 0 {
+		data = append(pending, data...)
+		delete(w.pending, typ)
+	}
+
+	data, rest := splitUtf8(data)
+	if len(rest) != 0 {
+		w.pending[typ] = append([]byte{}, rest...)
+	}
+
+	if len(data) == 0 {
+		return
+	}
+
+	err = w.event(typ, string(data))
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (w *Writer) Stream(typ string) io.Writer {
+	return &stream{
+		writer: w,
+		typ:    typ,
+	}
+}
+
+func (w *Writer) Resize(width, height int) (err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil {
+		return
+	}
+
+	err = w.event(Resize, fmt.Sprintf("%dx%d", width, height))
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (w *Writer) upload(db *database.Database) (err error) {
+	rec := w.Recording
+
+	file, err := os.Open(rec.Path)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "recording: Failed to open recording"),
+		}
+		return
+	}
+	defer file.Close()
+
+	gfs := db.SshRecordingsGridFs()
+
+	gfile, err := gfs.Create(rec.Id.Hex() + ".cast")
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+	gfile.SetContentType("application/x-asciicast")
+
+	_, err = io.Copy(gfile, file)
+	if err != nil {
+		gfile.Abort()
+		gfile.Close()
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "recording: Failed to upload recording"),
+		}
+		return
+	}
+
+	err = gfile.Close()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	fileId, _ := gfile.Id().(bson.ObjectId)
+	pth := rec.Path
+
+	rec.Storage = GridFs
+	rec.FileId = fileId
+	rec.Path = ""
+
+	err = utils.Remove(pth)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (w *Writer) Close(db *database.Database) (err error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil {
+		return
+	}
+
+	for typ, data := range w.pending {
+		e := w.event(typ, string(data))
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	w.pending = map[string][]byte{}
+
+	e := w.file.Close()
+	if e != nil && err == nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(e, "recording: Failed to close recording"),
+		}
+	}
+	w.file = nil
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"recording_id": w.Recording.Id.Hex(),
+			"error":        err,
+		}).Error("recording: Failed to finish recording")
+		err = nil
+	}
+
+	rec := w.Recording
+	rec.Active = false
+	rec.End = time.Now()
+	rec.Size = w.size
+
+	if settings.System.SshRecordingStorage == GridFs ||
+		!strings.Contains(node.Self.Type, node.Management) {
+
+		err = w.upload(db)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"recording_id": rec.Id.Hex(),
+				"error":        err,
+			}).Error("recording: Failed to store recording in GridFS")
+			err = nil
+		}
+	}
+
+	err = rec.CommitFields(db, set.NewSet(
+		"storage", "path", "file_id", "size", "active", "end"))
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func NewWriter(db *database.Database, rec *Recording, width, height int,
+	term string) (w *Writer, err error) {
+
+	dir := settings.System.SshRecordingPath
+	if dir == "" {
+		dir = constants.RecordingPath
+	}
+
+	err = utils.ExistsMkdir(dir, 0700)
+	if err != nil {
+		return
+	}
+
+	rec.Id = bson.NewObjectId()
+	rec.Storage = Disk
+	rec.Path = filepath.Join(dir, rec.Id.Hex()+".cast")
+	rec.Active = true
+	rec.Timestamp = time.Now()
+
+	file, err := os.OpenFile(rec.Path,
+		os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "recording: Failed to create recording"),
+		}
+		return
+	}
+
+	writer := &Writer{
+		Recording: rec,
+		file:      file,
+		start:     rec.Timestamp,
+		pending:   map[string][]byte{},
+	}
+
+	env := map[string]string{}
+	if term != "" {
+		env["TERM"] = term
+	}
+
+	err = writer.writeLine(&header{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: rec.Timestamp.Unix(),
+		Title:     rec.Principal + "@" + rec.Host,
+		Env:       env,
+	})
+	if err != nil {
+		file.Close()
+		os.Remove(rec.Path)
+		return
+	}
+
+	err = rec.Insert(db)
+	if err != nil {
+		file.Close()
+		os.Remove(rec.Path)
+		return
+	}
+
+	w = writer
+	return
+}
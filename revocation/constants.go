@@ -0,0 +1,30 @@
+package revocation
+
+import (
+	"github.com/dropbox/godropbox/container/set"
+)
+
+const (
+	Serial    = "serial"
+	KeyId     = "key_id"
+	PublicKey = "public_key"
+)
+
+const (
+	krlMagic         = 0x5353484b524c0a00
+	krlFormatVersion = 1
+
+	krlSectionCertificates = 1
+	krlSectionExplicitKey  = 2
+
+	krlSectionCertSerialList = 0x20
+	krlSectionCertKeyId      = 0x23
+)
+
+var (
+	types = set.NewSet(
+		Serial,
+		KeyId,
+		PublicKey,
+	)
+)
@@ -0,0 +1,161 @@
+package revocation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/authority"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"golang.org/x/crypto/ssh"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func writeUint32(b *bytes.Buffer, n uint32) {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, n)
+	b.Write(buf)
+}
+
+func writeUint64(b *bytes.Buffer, n uint64) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, n)
+	b.Write(buf)
+}
+
+func writeString(b *bytes.Buffer, data []byte) {
+	writeUint32(b, uint32(len(data)))
+	b.Write(data)
+}
+
+func writeSection(b *bytes.Buffer, typ byte, data []byte) {
+	b.WriteByte(typ)
+	writeString(b, data)
+}
+
+func GenerateKrl(authr *authority.Authority, version int64,
+	revocations []*Revocation) (krl []byte, err error) {
+
+	caKeys := []ssh.PublicKey{}
+	for _, pubKey := range authr.GetPublicKeys() {
+		caKey, _, _, _, e := ssh.ParseAuthorizedKey([]byte(pubKey))
+		if e != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(e, "revocation: Failed to parse authority key"),
+			}
+			return
+		}
+		caKeys = append(caKeys, caKey)
+	}
+
+	serials := uint64Slice{}
+	serialsSet := map[uint64]bool{}
+	keyIds := []string{}
+	keyIdsSet := map[string]bool{}
+	pubKeys := [][]byte{}
+	pubKeysSet := map[string]bool{}
+
+	for _, revc := range revocations {
+		switch revc.Type {
+		case Serial:
+			if revc.AuthorityId != authr.Id {
+				continue
+			}
+
+			serial, e := strconv.ParseUint(revc.Value, 10, 64)
+			if e != nil || serial == 0 || serialsSet[serial] {
+				continue
+			}
+			serialsSet[serial] = true
+			serials = append(serials, serial)
+			break
+		case KeyId:
+			if revc.AuthorityId != "" && revc.AuthorityId != authr.Id {
+				continue
+			}
+
+			if keyIdsSet[revc.Value] {
+				continue
+			}
+			keyIdsSet[revc.Value] = true
+			keyIds = append(keyIds, revc.Value)
+			break
+		case PublicKey:
+			if revc.AuthorityId != "" && revc.AuthorityId != authr.Id {
+				continue
+			}
+
+			pubKey, _, _, _, e := ssh.ParseAuthorizedKey(
+				[]byte(revc.Value))
+			if e != nil {
+				continue
+			}
+
+			pubKeyBlob := pubKey.Marshal()
+			if pubKeysSet[string(pubKeyBlob)] {
+				continue
+			}
+			pubKeysSet[string(pubKeyBlob)] = true
+			pubKeys = append(pubKeys, pubKeyBlob)
+			break
+		}
+	}
+
+	sort.Sort(serials)
+	sort.Strings(keyIds)
+
+	b := &bytes.Buffer{}
+
+	writeUint64(b, krlMagic)
+	writeUint32(b, krlFormatVersion)
+	writeUint64(b, uint64(version))
+	writeUint64(b, uint64(time.Now().Unix()))
+	writeUint64(b, 0)
+	writeString(b, nil)
+	writeString(b, []byte(authr.Name))
+
+	if len(serials) > 0 || len(keyIds) > 0 {
+		for _, caKey := range caKeys {
+			certs := &bytes.Buffer{}
+			writeString(certs, caKey.Marshal())
+			writeString(certs, nil)
+
+			if len(serials) > 0 {
+				sect := &bytes.Buffer{}
+				for _, serial := range serials {
+					writeUint64(sect, serial)
+				}
+				writeSection(certs, krlSectionCertSerialList, sect.Bytes())
+			}
+
+			if len(keyIds) > 0 {
+				sect := &bytes.Buffer{}
+				for _, keyId := range keyIds {
+					writeString(sect, []byte(keyId))
+				}
+				writeSection(certs, krlSectionCertKeyId, sect.Bytes())
+			}
+
+			writeSection(b, krlSectionCertificates, certs.Bytes())
+		}
+	}
+
+	if len(pubKeys) > 0 {
+		sect := &bytes.Buffer{}
+		for _, pubKey := range pubKeys {
+			writeString(sect, pubKey)
+		}
+		writeSection(b, krlSectionExplicitKey, sect.Bytes())
+	}
+
+	krl = b.Bytes()
+
+	return
+}
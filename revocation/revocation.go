@@ -0,0 +1,238 @@
+package revocation
+
+import (
+	"bytes"
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/mgo.v2/bson"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Revocation struct {
+	Id          bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	AuthorityId bson.ObjectId `bson:"authority_id,omitempty" json:"authority_id"`
+	UserId      bson.ObjectId `bson:"user_id,omitempty" json:"user_id"`
+	Type        string        `bson:"type" json:"type"`
+	Value       string        `bson:"value" json:"value"`
+	Comment     string        `bson:"comment" json:"comment"`
+	Timestamp   time.Time     `bson:"timestamp" json:"timestamp"`
+	Expires     time.Time     `bson:"expires,omitempty" json:"expires"`
+}
+
+func (r *Revocation) Validate(db *database.Database) (
+	errData *errortypes.ErrorData, err error) {
+
+	r.Value = strings.TrimSpace(r.Value)
+
+	if !types.Contains(r.Type) {
+		errData = &errortypes.ErrorData{
+			Error:   "revocation_type_invalid",
+			Message: "Revocation type is invalid",
+		}
+		return
+	}
+
+	if r.Value == "" {
+		errData = &errortypes.ErrorData{
+			Error:   "revocation_value_invalid",
+			Message: "Revocation value is required",
+		}
+		return
+	}
+
+	switch r.Type {
+	case Serial:
+		if r.AuthorityId == "" {
+			errData = &errortypes.ErrorData{
+				Error:   "revocation_authority_required",
+				Message: "Authority must be set for serial revocations",
+			}
+			return
+		}
+
+		_, e := strconv.ParseUint(r.Value, 10, 64)
+		if e != nil {
+			errData = &errortypes.ErrorData{
+				Error:   "revocation_serial_invalid",
+				Message: "Serial must be a positive integer",
+			}
+			return
+		}
+
+		if r.Expires.IsZero() {
+			r.Expires, err = serialExpires(db, r.AuthorityId, r.Value)
+			if err != nil {
+				return
+			}
+		}
+		break
+	case PublicKey:
+		pubKey, _, _, _, e := ssh.ParseAuthorizedKey([]byte(r.Value))
+		if e != nil {
+			errData = &errortypes.ErrorData{
+				Error:   "revocation_public_key_invalid",
+				Message: "Public key is invalid",
+			}
+			return
+		}
+
+		if _, ok := pubKey.(*ssh.Certificate); ok {
+			errData = &errortypes.ErrorData{
+				Error:   "revocation_public_key_invalid",
+				Message: "Revoke certificates by serial or key ID",
+			}
+			return
+		}
+		break
+	}
+
+	if r.Timestamp.IsZero() {
+		r.Timestamp = time.Now()
+	}
+
+	return
+}
+
+func (r *Revocation) Matches(authrId bson.ObjectId,
+	cert *ssh.Certificate) bool {
+
+	switch r.Type {
+	case Serial:
+		if r.AuthorityId != authrId {
+			return false
+		}
+
+		serial, err := strconv.ParseUint(r.Value, 10, 64)
+		if err != nil {
+			return false
+		}
+
+		return serial == cert.Serial
+	case KeyId:
+		if r.AuthorityId != "" && r.AuthorityId != authrId {
+			return false
+		}
+
+		return r.Value == cert.KeyId
+	case PublicKey:
+		if r.AuthorityId != "" && r.AuthorityId != authrId {
+			return false
+		}
+
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(r.Value))
+		if err != nil {
+			return false
+		}
+
+		return bytes.Equal(pubKey.Marshal(), cert.Key.Marshal())
+	}
+
+	return false
+}
+
+func IsRevoked(db *database.Database, authrId bson.ObjectId,
+	cert *ssh.Certificate) (revoked bool, err error) {
+
+	revcs, err := GetAuthority(db, authrId)
+	if err != nil {
+		return
+	}
+
+	for _, revc := range revcs {
+		if revc.Matches(authrId, cert) {
+			revoked = true
+			return
+		}
+	}
+
+	return
+}
+
+// Authorities must be in the same order as the certificates
+func HasActive(db *database.Database, authrIds []bson.ObjectId,
+	certs []string) (active bool, err error) {
+
+	now := uint64(time.Now().Unix())
+
+	for i, certStr := range certs {
+		if i >= len(authrIds) {
+			break
+		}
+
+		pubKey, _, _, _, e := ssh.ParseAuthorizedKey([]byte(certStr))
+		if e != nil {
+			continue
+		}
+
+		cert, ok := pubKey.(*ssh.Certificate)
+		if !ok || now < cert.ValidAfter || now >= cert.ValidBefore {
+			continue
+		}
+
+		revoked, e := IsRevoked(db, authrIds[i], cert)
+		if e != nil {
+			err = e
+			return
+		}
+
+		if !revoked {
+			active = true
+			return
+		}
+	}
+
+	return
+}
+
+func (r *Revocation) Commit(db *database.Database) (err error) {
+	coll := db.SshRevocations()
+
+	err = coll.Commit(r.Id, r)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (r *Revocation) CommitFields(db *database.Database, fields set.Set) (
+	err error) {
+
+	coll := db.SshRevocations()
+
+	err = coll.CommitFields(r.Id, r, fields)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (r *Revocation) Insert(db *database.Database) (err error) {
+	coll := db.SshRevocations()
+
+	if r.Id != "" {
+		err = &errortypes.DatabaseError{
+			errors.New("revocation: Revocation already exists"),
+		}
+		return
+	}
+
+	err = coll.Insert(r)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	err = incrementVersion(db, r.AuthorityId)
+	if err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,227 @@
+package revocation
+
+import (
+	"github.com/hillrnate/pritunl-zero/authority"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/ssh"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+type krlVersion struct {
+	Id      bson.ObjectId `bson:"_id"`
+	Version int64         `bson:"version"`
+}
+
+func GetVersion(db *database.Database, authrId bson.ObjectId) (
+	version int64, err error) {
+
+	coll := db.SshKrlVersions()
+	krlVer := &krlVersion{}
+
+	err = coll.FindOneId(authrId, krlVer)
+	if err != nil {
+		err = database.IgnoreNotFoundError(err)
+		return
+	}
+
+	version = krlVer.Version
+	return
+}
+
+// Revocations without an authority increment every authority version
+func incrementVersion(db *database.Database, authrId bson.ObjectId) (
+	err error) {
+
+	coll := db.SshKrlVersions()
+	authrIds := []bson.ObjectId{}
+
+	if authrId != "" {
+		authrIds = append(authrIds, authrId)
+	} else {
+		cursor := db.Authorities().Find(&bson.M{}).Select(&bson.M{
+			"_id": 1,
+		}).Iter()
+
+		authr := &authority.Authority{}
+		for cursor.Next(authr) {
+			authrIds = append(authrIds, authr.Id)
+			authr = &authority.Authority{}
+		}
+
+		err = cursor.Close()
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+	}
+
+	for _, authrId := range authrIds {
+		_, err = coll.UpsertId(authrId, &bson.M{
+			"$inc": &bson.M{
+				"version": 1,
+			},
+		})
+		if err != nil {
+			err = database.ParseError(err)
+			return
+		}
+	}
+
+	return
+}
+
+// Unknown serials return a zero time and the revocation is kept
+func serialExpires(db *database.Database, authrId bson.ObjectId,
+	serial string) (expires time.Time, err error) {
+
+	certs, err := ssh.LookupCertificates(db, authrId, serial, "")
+	if err != nil {
+		return
+	}
+
+	for _, cert := range certs {
+		for _, info := range cert.CertificatesInfo {
+			if info.AuthorityId == authrId && info.Serial == serial &&
+				info.Expires.After(expires) {
+
+				expires = info.Expires
+			}
+		}
+	}
+
+	return
+}
+
+func Get(db *database.Database, revcId bson.ObjectId) (
+	revc *Revocation, err error) {
+
+	coll := db.SshRevocations()
+	revc = &Revocation{}
+
+	err = coll.FindOneId(revcId, revc)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func GetAll(db *database.Database, query *bson.M) (
+	revcs []*Revocation, err error) {
+
+	coll := db.SshRevocations()
+	revcs = []*Revocation{}
+
+	cursor := coll.Find(query).Sort("-timestamp").Iter()
+
+	revc := &Revocation{}
+	for cursor.Next(revc) {
+		revcs = append(revcs, revc)
+		revc = &Revocation{}
+	}
+
+	err = cursor.Close()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func GetAuthority(db *database.Database, authrId bson.ObjectId) (
+	revcs []*Revocation, err error) {
+
+	revcs, err = GetAll(db, &bson.M{
+		"$or": []*bson.M{
+			&bson.M{
+				"authority_id": authrId,
+			},
+			&bson.M{
+				"authority_id": &bson.M{
+					"$exists": false,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RevokeUser(db *database.Database, userId bson.ObjectId,
+	comment string) (err error) {
+
+	coll := db.SshRevocations()
+	now := time.Now()
+
+	certs, err := ssh.GetActiveCertificates(db, userId)
+	if err != nil {
+		return
+	}
+
+	for _, cert := range certs {
+		for i, info := range cert.CertificatesInfo {
+			if i >= len(cert.AuthorityIds) || info.Expires.Before(now) {
+				continue
+			}
+
+			change, e := coll.Upsert(&bson.M{
+				"authority_id": cert.AuthorityIds[i],
+				"type":         Serial,
+				"value":        info.Serial,
+			}, &bson.M{
+				"$setOnInsert": &Revocation{
+					AuthorityId: cert.AuthorityIds[i],
+					UserId:      userId,
+					Type:        Serial,
+					Value:       info.Serial,
+					Comment:     comment,
+					Timestamp:   now,
+					Expires:     info.Expires,
+				},
+			})
+			if e != nil {
+				err = database.ParseError(e)
+				return
+			}
+
+			if change.UpsertedId != nil {
+				err = incrementVersion(db, cert.AuthorityIds[i])
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	return
+}
+
+func Remove(db *database.Database, revcId bson.ObjectId) (err error) {
+	coll := db.SshRevocations()
+
+	revc, err := Get(db, revcId)
+	if err != nil {
+		err = database.IgnoreNotFoundError(err)
+		return
+	}
+
+	_, err = coll.RemoveAll(&bson.M{
+		"_id": revcId,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	err = incrementVersion(db, revc.AuthorityId)
+	if err != nil {
+		return
+	}
+
+	return
+}
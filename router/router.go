@@ -63,7 +63,7 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, re *http.Request) {
 	} else if strings.Contains(r.typ, node.User) && hst == r.userDomain {
 		r.uRouter.ServeHTTP(w, re)
 		return
-	} else {
+	} else if r.pRouter != nil {
 		if !r.proxy.ServeHTTP(w, re) {
 			r.pRouter.ServeHTTP(w, re)
 		}
@@ -147,7 +147,7 @@ func (r *Router) startRedirect() {
 }
 
 func (r *Router) initWeb() (err error) {
-	r.typ = node.Self.Type
+	r.typ = node.Self.GetWebType()
 	r.managementDomain = node.Self.ManagementDomain
 	r.userDomain = node.Self.UserDomain
 	r.certificates = node.Self.CertificateObjs
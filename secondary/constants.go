@@ -14,4 +14,5 @@ const (
 	Proxy     = "proxy"
 	Authority = "authority"
 	Keybase   = "keybase"
+	SshKey    = "ssh_key"
 )
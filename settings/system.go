@@ -23,6 +23,12 @@ type system struct {
 	AcmeKeyAlgorithm     string `bson:"acme_key_algorithm" default:"rsa"`
 	SshPubKeyLen         int    `bson:"ssh_pub_key_len" default:"5000"`
 	SshHostTokenLen      int    `bson:"ssh_host_token_len" default:"10"`
+	SshHostPrincipalLen  int    `bson:"ssh_host_principal_len" default:"20"`
+	SshDeviceExpire      int    `bson:"ssh_device_expire" default:"600"`
+	SshDeviceInterval    int    `bson:"ssh_device_interval" default:"5"`
+	SshRecordingStorage  string `bson:"ssh_recording_storage" default:"disk"`
+	SshRecordingPath     string `bson:"ssh_recording_path"`
+	SshRecordingExpire   int    `bson:"ssh_recording_expire" default:"90"`
 }
 
 func newSystem() interface{} {
@@ -3,20 +3,30 @@ package ssh
 import (
 	"fmt"
 	"github.com/dropbox/godropbox/container/set"
+	"github.com/dropbox/godropbox/errors"
 	"github.com/hillrnate/pritunl-zero/agent"
 	"github.com/hillrnate/pritunl-zero/authority"
 	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/policy"
+	"github.com/hillrnate/pritunl-zero/sshkey"
 	"github.com/hillrnate/pritunl-zero/user"
 	"github.com/hillrnate/pritunl-zero/utils"
+	"golang.org/x/crypto/ssh"
 	"gopkg.in/mgo.v2/bson"
+	"sort"
+	"strings"
 	"time"
 )
 
 type Info struct {
-	Serial     string    `bson:"serial" json:"serial"`
-	Expires    time.Time `bson:"expires" json:"expires"`
-	Principals []string  `bson:"principals" json:"principals"`
-	Extensions []string  `bson:"extensions" json:"extensions"`
+	AuthorityId     bson.ObjectId     `bson:"authority_id,omitempty" json:"authority_id"`
+	Serial          string            `bson:"serial" json:"serial"`
+	ValidAfter      time.Time         `bson:"valid_after" json:"valid_after"`
+	Expires         time.Time         `bson:"expires" json:"expires"`
+	Principals      []string          `bson:"principals" json:"principals"`
+	Extensions      []string          `bson:"extensions" json:"extensions"`
+	CriticalOptions map[string]string `bson:"critical_options" json:"critical_options"`
 }
 
 type Host struct {
@@ -31,11 +41,13 @@ type Certificate struct {
 	AuthorityIds           []bson.ObjectId `bson:"authority_ids" json:"authority_ids"`
 	Timestamp              time.Time       `bson:"timestamp" json:"timestamp"`
 	PubKey                 string          `bson:"pub_key"`
+	Fingerprint            string          `bson:"fingerprint" json:"fingerprint"`
 	Hosts                  []*Host         `bson:"hosts" json:"hosts"`
 	CertificateAuthorities []string        `bson:"certificate_authorities" json:"-"`
 	Certificates           []string        `bson:"certificates" json:"-"`
 	CertificatesInfo       []*Info         `bson:"certificates_info" json:"certificates_info"`
 	Agent                  *agent.Agent    `bson:"agent" json:"agent"`
+	ConfigToken            string          `bson:"config_token,omitempty" json:"-"`
 }
 
 func (c *Certificate) Commit(db *database.Database) (err error) {
@@ -65,6 +77,10 @@ func (c *Certificate) CommitFields(db *database.Database, fields set.Set) (
 func (c *Certificate) Insert(db *database.Database) (err error) {
 	coll := db.SshCertificates()
 
+	if c.Fingerprint == "" {
+		c.Fingerprint = Fingerprint(c.PubKey)
+	}
+
 	err = coll.Insert(c)
 	if err != nil {
 		err = database.ParseError(err)
@@ -88,6 +104,22 @@ func GetCertificate(db *database.Database, certId bson.ObjectId) (
 	return
 }
 
+func GetConfigCertificate(db *database.Database, token string) (
+	cert *Certificate, err error) {
+
+	coll := db.SshCertificates()
+	cert = &Certificate{}
+
+	err = coll.FindOne(&bson.M{
+		"config_token": token,
+	}, cert)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
 func GetCertificates(db *database.Database, userId bson.ObjectId,
 	page, pageCount int) (certs []*Certificate, count int, err error) {
 
@@ -123,61 +155,195 @@ func GetCertificates(db *database.Database, userId bson.ObjectId,
 	return
 }
 
+// Serials are only unique within an authority
+func LookupCertificates(db *database.Database, authrId bson.ObjectId,
+	serial, fingerprint string) (certs []*Certificate, err error) {
+
+	coll := db.SshCertificates()
+	certs = []*Certificate{}
+
+	if serial != "" && authrId == "" {
+		return
+	}
+
+	query := bson.M{}
+	if serial != "" {
+		query["certificates_info"] = &bson.M{
+			"$elemMatch": &bson.M{
+				"authority_id": authrId,
+				"serial":       serial,
+			},
+		}
+	} else if authrId != "" {
+		query["authority_ids"] = authrId
+	}
+	if fingerprint != "" {
+		query["fingerprint"] = fingerprint
+	}
+
+	if len(query) == 0 {
+		return
+	}
+
+	cursor := coll.Find(query).Sort("-timestamp").Limit(100).Iter()
+
+	cert := &Certificate{}
+	for cursor.Next(cert) {
+		certs = append(certs, cert)
+		cert = &Certificate{}
+	}
+
+	err = cursor.Close()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func GetActiveCertificates(db *database.Database, userId bson.ObjectId) (
+	certs []*Certificate, err error) {
+
+	coll := db.SshCertificates()
+	certs = []*Certificate{}
+
+	cursor := coll.Find(&bson.M{
+		"user_id": userId,
+		"certificates_info.expires": &bson.M{
+			"$gt": time.Now(),
+		},
+	}).Iter()
+
+	cert := &Certificate{}
+	for cursor.Next(cert) {
+		certs = append(certs, cert)
+		cert = &Certificate{}
+	}
+
+	err = cursor.Close()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
 func NewCertificate(db *database.Database, authrs []*authority.Authority,
-	usr *user.User, agnt *agent.Agent, pubKey string) (cert *Certificate,
-	err error) {
+	usr *user.User, agnt *agent.Agent, pubKey string, expire int) (
+	cert *Certificate, errData *errortypes.ErrorData, err error) {
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey(
+		[]byte(strings.TrimSpace(pubKey)))
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "ssh: Failed to parse public key"),
+		}
+		return
+	}
 
 	cert = &Certificate{
-		Id:           bson.NewObjectId(),
-		UserId:       usr.Id,
-		AuthorityIds: []bson.ObjectId{},
-		Timestamp:    time.Now(),
-		PubKey:       pubKey,
-		Hosts:        []*Host{},
+		Id:                     bson.NewObjectId(),
+		UserId:                 usr.Id,
+		AuthorityIds:           []bson.ObjectId{},
+		Timestamp:              time.Now(),
+		PubKey:                 pubKey,
+		Hosts:                  []*Host{},
 		CertificateAuthorities: []string{},
 		Certificates:           []string{},
 		CertificatesInfo:       []*Info{},
 		Agent:                  agnt,
 	}
 
+	cert.ConfigToken, err = utils.RandStr(48)
+	if err != nil {
+		return
+	}
+
+	authrIds := []bson.ObjectId{}
+	for _, authr := range authrs {
+		authrIds = append(authrIds, authr.Id)
+	}
+
+	policies, err := policy.GetAuthoritiesRoles(db, authrIds, usr.Roles)
+	if err != nil {
+		return
+	}
+
+	clientIp := ""
+	if agnt != nil {
+		clientIp = agnt.Ip
+	}
+
+	registered := false
+	for _, authr := range authrs {
+		if authr.RegisteredKeys {
+			registered, err = sshkey.IsRegistered(
+				db, usr.Id, Fingerprint(pubKey))
+			if err != nil {
+				return
+			}
+			break
+		}
+	}
+
+	keyDenied := false
+
 	for _, authr := range authrs {
 		if !authr.UserHasAccess(usr) {
 			continue
 		}
 
-		crt, certStr, e := authr.CreateCertificate(usr, pubKey)
+		if authr.RegisteredKeys && !registered {
+			continue
+		}
+
+		if !policy.KeyAllowed(policies, authr.Id, key) {
+			keyDenied = true
+			continue
+		}
+
+		extensions := policy.Extensions(
+			policies, authr.Id, authr.Extensions)
+
+		authrExpire := expire
+		policyExpire := policy.AuthorityExpire(policies, authr.Id)
+		if policyExpire > 0 && (authrExpire <= 0 ||
+			policyExpire < authrExpire) {
+
+			authrExpire = policyExpire
+		}
+
+		crt, certStr, e := authr.CreateCertificate(
+			db, usr, pubKey, extensions, clientIp, authrExpire)
 		if e != nil {
 			err = e
 			return
 		}
 
 		info := &Info{
-			Expires:    time.Unix(int64(crt.ValidBefore), 0),
-			Serial:     fmt.Sprintf("%d", crt.Serial),
-			Principals: crt.ValidPrincipals,
-			Extensions: []string{},
+			AuthorityId:     authr.Id,
+			ValidAfter:      time.Unix(int64(crt.ValidAfter), 0),
+			Expires:         time.Unix(int64(crt.ValidBefore), 0),
+			Serial:          fmt.Sprintf("%d", crt.Serial),
+			Principals:      crt.ValidPrincipals,
+			Extensions:      []string{},
+			CriticalOptions: crt.Permissions.CriticalOptions,
 		}
 
 		for permission := range crt.Permissions.Extensions {
 			info.Extensions = append(info.Extensions, permission)
 		}
+		sort.Strings(info.Extensions)
 
-		certAuthr := authr.GetCertAuthority()
-		if certAuthr != "" {
-			cert.CertificateAuthorities = append(
-				cert.CertificateAuthorities,
-				certAuthr,
-			)
-		}
+		cert.CertificateAuthorities = append(
+			cert.CertificateAuthorities,
+			authr.GetCertAuthority()...,
+		)
 
-		if authr.HostDomain != "" && (authr.StrictHostChecking ||
-			authr.HostProxy != "") {
-
-			hst := &Host{
-				Domain:             authr.GetHostDomain(),
-				ProxyHost:          authr.HostProxy,
-				StrictHostChecking: authr.StrictHostChecking,
-			}
+		hst := newHost(authr)
+		if hst != nil {
 			cert.Hosts = append(cert.Hosts, hst)
 		}
 
@@ -186,5 +352,14 @@ func NewCertificate(db *database.Database, authrs []*authority.Authority,
 		cert.CertificatesInfo = append(cert.CertificatesInfo, info)
 	}
 
+	if len(cert.Certificates) == 0 && keyDenied {
+		errData = &errortypes.ErrorData{
+			Error: "ssh_key_policy",
+			Message: fmt.Sprintf("SSH key type %s is not permitted",
+				authority.GetKeyAlg(key)),
+		}
+		return
+	}
+
 	return
 }
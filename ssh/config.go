@@ -0,0 +1,93 @@
+package ssh
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/hillrnate/pritunl-zero/authority"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/user"
+	"sort"
+)
+
+type Config struct {
+	Version    string `json:"version"`
+	SshConfig  string `json:"ssh_config"`
+	KnownHosts string `json:"known_hosts"`
+}
+
+func newHost(authr *authority.Authority) *Host {
+	if authr.HostDomain == "" || (!authr.StrictHostChecking &&
+		authr.HostProxy == "") {
+
+		return nil
+	}
+
+	return &Host{
+		Domain:             authr.GetHostDomain(),
+		ProxyHost:          authr.HostProxy,
+		StrictHostChecking: authr.StrictHostChecking,
+	}
+}
+
+func RenderConfig(hosts []*Host, certAuthrs []string) (conf *Config) {
+	sshConfig := ""
+	for _, hst := range hosts {
+		sshConfig += fmt.Sprintf("Host %s\n", hst.Domain)
+		if hst.ProxyHost != "" {
+			sshConfig += fmt.Sprintf("    ProxyJump %s\n", hst.ProxyHost)
+		}
+		if hst.StrictHostChecking {
+			sshConfig += "    StrictHostKeyChecking yes\n"
+		}
+	}
+
+	knownHosts := ""
+	for _, certAuthr := range certAuthrs {
+		knownHosts += certAuthr + "\n"
+	}
+
+	hash := sha256.Sum256([]byte(sshConfig + "\x00" + knownHosts))
+	version := fmt.Sprintf("%x", hash[:8])
+	header := fmt.Sprintf("# pritunl-zero %s\n", version)
+
+	conf = &Config{
+		Version:    version,
+		SshConfig:  header + sshConfig,
+		KnownHosts: header + knownHosts,
+	}
+
+	return
+}
+
+func GetConfig(db *database.Database, usr *user.User) (
+	conf *Config, err error) {
+
+	authrs, err := authority.GetAll(db)
+	if err != nil {
+		return
+	}
+
+	sort.Slice(authrs, func(i, j int) bool {
+		return authrs[i].Id < authrs[j].Id
+	})
+
+	hosts := []*Host{}
+	certAuthrs := []string{}
+
+	for _, authr := range authrs {
+		if !authr.UserHasAccess(usr) {
+			continue
+		}
+
+		hst := newHost(authr)
+		if hst != nil {
+			hosts = append(hosts, hst)
+		}
+
+		certAuthrs = append(certAuthrs, authr.GetCertAuthority()...)
+	}
+
+	conf = RenderConfig(hosts, certAuthrs)
+
+	return
+}
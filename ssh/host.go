@@ -7,7 +7,11 @@ import (
 	"github.com/hillrnate/pritunl-zero/authority"
 	"github.com/hillrnate/pritunl-zero/database"
 	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/host"
+	"github.com/hillrnate/pritunl-zero/node"
+	"github.com/hillrnate/pritunl-zero/policy"
 	"github.com/hillrnate/pritunl-zero/settings"
+	"golang.org/x/crypto/ssh"
 	"gopkg.in/mgo.v2/bson"
 	"net/http"
 	"strings"
@@ -15,8 +19,9 @@ import (
 )
 
 func NewHostCertificate(db *database.Database, hostname string, port int,
-	tokens []string, r *http.Request, pubKey string) (
-	cert *Certificate, errData *errortypes.ErrorData, err error) {
+	principals []string, tokens []string, r *http.Request, pubKey string) (
+	cert *Certificate, reasons []string, errData *errortypes.ErrorData,
+	err error) {
 
 	pubKey = strings.TrimSpace(pubKey)
 
@@ -27,6 +32,13 @@ func NewHostCertificate(db *database.Database, hostname string, port int,
 		return
 	}
 
+	if len(principals) > settings.System.SshHostPrincipalLen {
+		err = errortypes.ParseError{
+			errors.New("ssh: Too many principals"),
+		}
+		return
+	}
+
 	if len(pubKey) > settings.System.SshPubKeyLen {
 		err = errortypes.ParseError{
 			errors.New("ssh: Public key too long"),
@@ -34,6 +46,14 @@ func NewHostCertificate(db *database.Database, hostname string, port int,
 		return
 	}
 
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pubKey))
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "ssh: Failed to parse public key"),
+		}
+		return
+	}
+
 	agnt, err := agent.Parse(db, r)
 	if err != nil {
 		return
@@ -62,37 +82,124 @@ func NewHostCertificate(db *database.Database, hostname string, port int,
 		return
 	}
 
+	authrIds := []bson.ObjectId{}
+	for _, authr := range authrs {
+		authrIds = append(authrIds, authr.Id)
+	}
+
+	authrPolicies, err := policy.GetAuthoritiesRoles(
+		db, authrIds, []string{})
+	if err != nil {
+		return
+	}
+
+	// Role policies apply to users, host keys are only checked against
+	// policies without roles
+	policies := []*policy.Policy{}
+	for _, polcy := range authrPolicies {
+		if len(polcy.Roles) == 0 {
+			policies = append(policies, polcy)
+		}
+	}
+
+	reasons = []string{}
+
+	clientIp := node.Self.GetRemoteAddr(r)
+
 	for _, authr := range authrs {
-		if !authr.HostnameValidate(hostname, port, pubKey) {
+		domain := authr.GetDomain(hostname)
+
+		tokn := authr.GetToken(tokens)
+		if tokn == nil {
+			continue
+		}
+
+		if tokn.Expired() {
+			reasons = append(reasons, fmt.Sprintf(
+				"%s: Token has expired", domain))
 			continue
 		}
 
-		crt, certStr, e := authr.CreateHostCertificate(hostname, pubKey)
+		if !tokn.Allowed(hostname, clientIp) {
+			reasons = append(reasons, fmt.Sprintf(
+				"%s: Token is not allowed for host", domain))
+			continue
+		}
+
+		blocked, e := host.IsBlocked(db, authr.Id, hostname)
+		if e != nil {
+			err = e
+			return
+		}
+
+		if blocked {
+			reasons = append(reasons, fmt.Sprintf(
+				"%s: Hostname is blocked", domain))
+			continue
+		}
+
+		if !policy.KeyAllowed(policies, authr.Id, key) {
+			reasons = append(reasons, fmt.Sprintf(
+				"%s: Host key type %s is not permitted",
+				domain, authority.GetKeyAlg(key)))
+			continue
+		}
+
+		valid, validReasons := authr.HostnameValidate(hostname, port, pubKey)
+		if !valid {
+			reasons = append(reasons, validReasons...)
+			continue
+		}
+
+		certPrincipals, principalReasons := authr.GetHostPrincipals(
+			tokn, hostname, port, pubKey, clientIp, principals)
+		reasons = append(reasons, principalReasons...)
+
+		crt, certStr, e := authr.CreateHostCertificate(
+			db, hostname, certPrincipals, pubKey)
 		if e != nil {
 			err = e
 			return
 		}
 
 		info := &Info{
-			Expires:    time.Unix(int64(crt.ValidBefore), 0),
-			Serial:     fmt.Sprintf("%d", crt.Serial),
-			Principals: crt.ValidPrincipals,
-			Extensions: []string{},
+			AuthorityId: authr.Id,
+			ValidAfter:  time.Unix(int64(crt.ValidAfter), 0),
+			Expires:     time.Unix(int64(crt.ValidBefore), 0),
+			Serial:      fmt.Sprintf("%d", crt.Serial),
+			Principals:  crt.ValidPrincipals,
+			Extensions:  []string{},
 		}
 
 		for permission := range crt.Permissions.Extensions {
 			info.Extensions = append(info.Extensions, permission)
 		}
 
+		err = authr.TokenUsed(db, tokn)
+		if err != nil {
+			return
+		}
+
+		err = host.Seen(db, authr.Id, hostname, crt.ValidPrincipals,
+			Fingerprint(pubKey), clientIp, tokn.Id, info.Expires)
+		if err != nil {
+			return
+		}
+
 		cert.AuthorityIds = append(cert.AuthorityIds, authr.Id)
 		cert.Certificates = append(cert.Certificates, certStr)
 		cert.CertificatesInfo = append(cert.CertificatesInfo, info)
 	}
 
 	if len(cert.Certificates) == 0 {
+		message := "No certificates are available"
+		if len(reasons) > 0 {
+			message += ", " + strings.Join(reasons, ", ")
+		}
+
 		errData = &errortypes.ErrorData{
 			Error:   "certificate_unavailable",
-			Message: "No certificates are available",
+			Message: message,
 		}
 		return
 	}
@@ -0,0 +1,98 @@
+package ssh
+
+import (
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/authority"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/node"
+	"github.com/hillrnate/pritunl-zero/policy"
+	"github.com/hillrnate/pritunl-zero/settings"
+	"github.com/hillrnate/pritunl-zero/user"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+)
+
+func GetAuthorizedPrincipals(db *database.Database, hostname, account,
+	keyId string, tokens []string, r *http.Request) (principals []string,
+	errData *errortypes.ErrorData, err error) {
+
+	principals = []string{}
+
+	if len(tokens) > settings.System.SshHostTokenLen {
+		err = errortypes.ParseError{
+			errors.New("ssh: Too many tokens"),
+		}
+		return
+	}
+
+	authrs, err := authority.GetTokens(db, tokens)
+	if err != nil {
+		return
+	}
+
+	clientIp := node.Self.GetRemoteAddr(r)
+
+	tokenAuthrs := []*authority.Authority{}
+	for _, authr := range authrs {
+		tokn := authr.GetToken(tokens)
+		if tokn == nil || tokn.Expired() ||
+			!tokn.Allowed(hostname, clientIp) {
+
+			continue
+		}
+
+		tokenAuthrs = append(tokenAuthrs, authr)
+	}
+
+	if len(tokenAuthrs) == 0 {
+		errData = &errortypes.ErrorData{
+			Error:   "invalid_tokens",
+			Message: "All tokens are invalid",
+		}
+		return
+	}
+
+	if account == "" || !bson.IsObjectIdHex(keyId) {
+		return
+	}
+
+	usr, err := user.Get(db, bson.ObjectIdHex(keyId))
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			err = nil
+		}
+		return
+	}
+
+	if usr.Disabled {
+		return
+	}
+
+	authrIds := []bson.ObjectId{}
+	for _, authr := range tokenAuthrs {
+		authrIds = append(authrIds, authr.Id)
+	}
+
+	policies, err := policy.GetAuthoritiesRoles(db, authrIds, usr.Roles)
+	if err != nil {
+		return
+	}
+
+	for _, authr := range tokenAuthrs {
+		if !authr.UserHasAccess(usr) ||
+			!policy.AccountAllowed(policies, authr.Id, account) {
+
+			continue
+		}
+
+		for _, principal := range authr.GetPrincipals(usr) {
+			if principal == account {
+				principals = append(principals, principal)
+				return
+			}
+		}
+	}
+
+	return
+}
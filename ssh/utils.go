@@ -0,0 +1,16 @@
+package ssh
+
+import (
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+func Fingerprint(pubKey string) string {
+	key, _, _, _, err := ssh.ParseAuthorizedKey(
+		[]byte(strings.TrimSpace(pubKey)))
+	if err != nil {
+		return ""
+	}
+
+	return ssh.FingerprintSHA256(key)
+}
@@ -0,0 +1,291 @@
+package sshclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type challengeData struct {
+	Token     string `json:"token"`
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+type deviceData struct {
+	PublicKey string `json:"public_key"`
+}
+
+type deviceCodeData struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationUri         string `json:"verification_uri"`
+	VerificationUriComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type deviceTokenData struct {
+	DeviceCode string `json:"device_code"`
+}
+
+type hostData struct {
+	Domain             string `json:"domain"`
+	ProxyHost          string `json:"proxy_host"`
+	StrictHostChecking bool   `json:"strict_host_checking"`
+}
+
+type configData struct {
+	Version    string `json:"version"`
+	SshConfig  string `json:"ssh_config"`
+	KnownHosts string `json:"known_hosts"`
+}
+
+type certificateData struct {
+	Token                  string      `json:"token"`
+	Certificates           []string    `json:"certificates"`
+	CertificateAuthorities []string    `json:"certificate_authorities"`
+	Hosts                  []*hostData `json:"hosts"`
+	Config                 *configData `json:"config"`
+}
+
+type Client struct {
+	server string
+	client *http.Client
+}
+
+func (c *Client) request(method, path string, reqData, respData interface{},
+	errData *errortypes.ErrorData) (status int, err error) {
+
+	body, err := json.Marshal(reqData)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "sshclient: Failed to marshal request"),
+		}
+		return
+	}
+
+	req, err := http.NewRequest(method, c.server+path, bytes.NewReader(body))
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "sshclient: Failed to create request"),
+		}
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "sshclient: Request failed"),
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	status = resp.StatusCode
+
+	switch status {
+	case 200:
+		err = json.NewDecoder(resp.Body).Decode(respData)
+		break
+	case 400, 412:
+		if errData != nil {
+			err = json.NewDecoder(resp.Body).Decode(errData)
+		}
+		break
+	case 401:
+		// Denied requests only include error data when denied by policy
+		if errData != nil {
+			json.NewDecoder(resp.Body).Decode(errData)
+		}
+		break
+	}
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "sshclient: Failed to parse response"),
+		}
+		return
+	}
+
+	return
+}
+
+func parseStatus(status int, errData *errortypes.ErrorData) (err error) {
+	switch status {
+	case 401:
+		if errData != nil && errData.Message != "" {
+			err = &errortypes.AuthenticationError{
+				errors.Newf("sshclient: Certificate request was denied, %s",
+					errData.Message),
+			}
+		} else {
+			err = &errortypes.AuthenticationError{
+				errors.New("sshclient: Certificate request was denied"),
+			}
+		}
+		break
+	case 404:
+		err = &errortypes.NotFoundError{
+			errors.New("sshclient: Certificate request has expired"),
+		}
+		break
+	case 412:
+		err = &errortypes.AuthenticationError{
+			errors.Newf("sshclient: Certificate unavailable, %s",
+				errData.Message),
+		}
+		break
+	default:
+		err = &errortypes.RequestError{
+			errors.Newf("sshclient: Bad status %d", status),
+		}
+	}
+
+	return
+}
+
+func (c *Client) Challenge(pubKey string) (
+	data *certificateData, err error) {
+
+	chal := &challengeData{}
+	status, err := c.request("POST", "/ssh/challenge", &challengeData{
+		PublicKey: pubKey,
+	}, chal, nil)
+	if err != nil {
+		return
+	}
+
+	if status != 200 {
+		err = &errortypes.RequestError{
+			errors.Newf("sshclient: Challenge request bad status %d",
+				status),
+		}
+		return
+	}
+
+	url := c.server + "/ssh?ssh-token=" + chal.Token
+
+	fmt.Println("Open the link below to approve the SSH key:")
+	fmt.Println(url)
+	openBrowser(url)
+
+	for {
+		data = &certificateData{}
+		errData := &errortypes.ErrorData{}
+
+		status, err = c.request("PUT", "/ssh/challenge", &challengeData{
+			Token: chal.Token,
+		}, data, errData)
+		if err != nil {
+			data = nil
+			return
+		}
+
+		switch status {
+		case 200:
+			return
+		case 205:
+			continue
+		default:
+			data = nil
+			err = parseStatus(status, errData)
+			return
+		}
+	}
+}
+
+func (c *Client) Device(pubKey string) (data *certificateData, err error) {
+	devc := &deviceCodeData{}
+	status, err := c.request("POST", "/ssh/device", &deviceData{
+		PublicKey: pubKey,
+	}, devc, nil)
+	if err != nil {
+		return
+	}
+
+	if status != 200 {
+		err = &errortypes.RequestError{
+			errors.Newf("sshclient: Device request bad status %d", status),
+		}
+		return
+	}
+
+	fmt.Printf("Go to %s and enter the code %s\n",
+		devc.VerificationUri, devc.UserCode)
+	fmt.Println("Or open the link below to approve the SSH key:")
+	fmt.Println(devc.VerificationUriComplete)
+
+	interval := devc.Interval
+	if interval < 1 {
+		interval = 5
+	}
+	expires := time.Now().Add(time.Duration(devc.ExpiresIn) * time.Second)
+
+	for time.Now().Before(expires) {
+		time.Sleep(time.Duration(interval) * time.Second)
+
+		data = &certificateData{}
+		errData := &errortypes.ErrorData{}
+
+		status, err = c.request("POST", "/ssh/device/token",
+			&deviceTokenData{
+				DeviceCode: devc.DeviceCode,
+			}, data, errData)
+		if err != nil {
+			data = nil
+			return
+		}
+
+		if status == 200 {
+			return
+		}
+		data = nil
+
+		if status != 400 {
+			err = parseStatus(status, errData)
+			return
+		}
+
+		switch errData.Error {
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			interval += 5
+			continue
+		case "access_denied":
+			err = &errortypes.AuthenticationError{
+				errors.Newf("sshclient: Certificate request was denied, %s",
+					strings.TrimSpace(errData.Message)),
+			}
+			return
+		default:
+			err = &errortypes.RequestError{
+				errors.Newf("sshclient: Device request failed, %s",
+					strings.TrimSpace(errData.Message)),
+			}
+			return
+		}
+	}
+
+	err = &errortypes.NotFoundError{
+		errors.New("sshclient: Certificate request has expired"),
+	}
+	return
+}
+
+func New(server string) (clnt *Client) {
+	clnt = &Client{
+		server: strings.TrimRight(strings.TrimSpace(server), "/"),
+		client: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+	}
+
+	return
+}
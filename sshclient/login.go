@@ -0,0 +1,237 @@
+package sshclient
+
+import (
+	"fmt"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Options struct {
+	Server  string
+	KeyPath string
+	Device  bool
+	Agent   bool
+}
+
+func certPath(keyPath string, i int) string {
+	if i == 0 {
+		return keyPath + "-cert.pub"
+	}
+	return fmt.Sprintf("%s-cert-%d.pub", keyPath, i)
+}
+
+func install(sshDir, keyPath string, data *certificateData) (err error) {
+	err = os.MkdirAll(sshDir, 0700)
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "sshclient: Failed to create ssh directory"),
+		}
+		return
+	}
+
+	for i, cert := range data.Certificates {
+		err = ioutil.WriteFile(certPath(keyPath, i),
+			[]byte(strings.TrimSpace(cert)+"\n"), 0644)
+		if err != nil {
+			err = &errortypes.WriteError{
+				errors.Wrap(err, "sshclient: Failed to write certificate"),
+			}
+			return
+		}
+	}
+
+	conf := data.Config
+	if conf == nil {
+		conf = &configData{
+			SshConfig:  blockStart + " local\n",
+			KnownHosts: blockStart + " local\n",
+		}
+
+		for _, hst := range data.Hosts {
+			conf.SshConfig += fmt.Sprintf("Host %s\n", hst.Domain)
+			if hst.ProxyHost != "" {
+				conf.SshConfig += fmt.Sprintf(
+					"    ProxyJump %s\n", hst.ProxyHost)
+			}
+			if hst.StrictHostChecking {
+				conf.SshConfig += "    StrictHostKeyChecking yes\n"
+			}
+		}
+
+		for _, certAuthr := range data.CertificateAuthorities {
+			conf.KnownHosts += certAuthr + "\n"
+		}
+	}
+
+	err = writeBlock(filepath.Join(sshDir, "known_hosts"),
+		conf.KnownHosts, 0644)
+	if err != nil {
+		return
+	}
+
+	// Match all ends the last host section of the block
+	sshConfig := strings.TrimRight(conf.SshConfig, "\n") + "\nMatch all\n"
+	for i := 1; i < len(data.Certificates); i++ {
+		sshConfig += fmt.Sprintf(
+			"    CertificateFile \"%s\"\n", certPath(keyPath, i))
+	}
+
+	err = writeBlock(filepath.Join(sshDir, "config"), sshConfig, 0600)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func loadAgent(keyPath string, data *certificateData) (err error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		err = &errortypes.ReadError{
+			errors.New("sshclient: SSH_AUTH_SOCK is not set"),
+		}
+		return
+	}
+
+	keyData, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "sshclient: Failed to read private key"),
+		}
+		return
+	}
+
+	privateKey, err := ssh.ParseRawPrivateKey(keyData)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "sshclient: Failed to parse private key, "+
+				"encrypted keys must be added with ssh-add"),
+		}
+		return
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		err = &errortypes.RequestError{
+			errors.Wrap(err, "sshclient: Failed to connect to ssh-agent"),
+		}
+		return
+	}
+	defer conn.Close()
+
+	agnt := agent.NewClient(conn)
+
+	for _, certStr := range data.Certificates {
+		pubKey, comment, _, _, e := ssh.ParseAuthorizedKey([]byte(certStr))
+		if e != nil {
+			err = &errortypes.ParseError{
+				errors.Wrap(e, "sshclient: Failed to parse certificate"),
+			}
+			return
+		}
+
+		cert, ok := pubKey.(*ssh.Certificate)
+		if !ok {
+			err = &errortypes.ParseError{
+				errors.New("sshclient: Response is not a certificate"),
+			}
+			return
+		}
+
+		lifetime := int64(cert.ValidBefore) - time.Now().Unix()
+		if lifetime <= 0 {
+			continue
+		}
+
+		err = agnt.Add(agent.AddedKey{
+			PrivateKey:   privateKey,
+			Certificate:  cert,
+			Comment:      comment,
+			LifetimeSecs: uint32(lifetime),
+		})
+		if err != nil {
+			err = &errortypes.WriteError{
+				errors.Wrap(err, "sshclient: Failed to add to ssh-agent"),
+			}
+			return
+		}
+	}
+
+	return
+}
+
+func Login(opts *Options) (err error) {
+	curUser, err := user.Current()
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "sshclient: Failed to get home directory"),
+		}
+		return
+	}
+	sshDir := filepath.Join(curUser.HomeDir, ".ssh")
+
+	keyPath := strings.TrimSuffix(opts.KeyPath, ".pub")
+	if keyPath == "" {
+		keyPath, err = findKey(sshDir)
+		if err != nil {
+			return
+		}
+	}
+
+	pubKeyData, err := ioutil.ReadFile(keyPath + ".pub")
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "sshclient: Failed to read public key"),
+		}
+		return
+	}
+	pubKey := strings.TrimSpace(string(pubKeyData))
+
+	clnt := New(opts.Server)
+
+	var data *certificateData
+	if opts.Device {
+		data, err = clnt.Device(pubKey)
+	} else {
+		data, err = clnt.Challenge(pubKey)
+	}
+	if err != nil {
+		return
+	}
+
+	if len(data.Certificates) == 0 {
+		err = &errortypes.NotFoundError{
+			errors.New("sshclient: No certificates in response"),
+		}
+		return
+	}
+
+	err = install(sshDir, keyPath, data)
+	if err != nil {
+		return
+	}
+
+	for i := range data.Certificates {
+		fmt.Printf("Certificate written to %s\n", certPath(keyPath, i))
+	}
+
+	if opts.Agent {
+		err = loadAgent(keyPath, data)
+		if err != nil {
+			return
+		}
+
+		fmt.Println("Certificates added to ssh-agent")
+	}
+
+	return
+}
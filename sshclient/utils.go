@@ -0,0 +1,237 @@
+package sshclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/dropbox/godropbox/errors"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	blockStart = "# pritunl-zero"
+	blockEnd   = "# pritunl-zero end"
+)
+
+var defaultKeys = []string{
+	"id_ed25519",
+	"id_ecdsa",
+	"id_rsa",
+}
+
+func openBrowser(url string) {
+	var cmd *exec.Cmd
+
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+		break
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+		break
+	default:
+		if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			return
+		}
+		cmd = exec.Command("xdg-open", url)
+	}
+
+	_ = cmd.Start()
+}
+
+func findKey(sshDir string) (keyPath string, err error) {
+	for _, name := range defaultKeys {
+		path := filepath.Join(sshDir, name)
+		if exists(path) && exists(path+".pub") {
+			keyPath = path
+			return
+		}
+	}
+
+	path := filepath.Join(sshDir, "id_ecdsa")
+	if exists(path) || exists(path+".pub") {
+		err = &errortypes.ReadError{
+			errors.Newf("sshclient: Incomplete key pair at '%s'", path),
+		}
+		return
+	}
+
+	err = generateKey(path)
+	if err != nil {
+		return
+	}
+	keyPath = path
+
+	return
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return !os.IsNotExist(err)
+}
+
+func writeNew(path string, data []byte, perm os.FileMode) (err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return
+	}
+
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+		return
+	}
+
+	err = file.Close()
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func generateKey(keyPath string) (err error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		err = &errortypes.ReadError{
+			errors.Wrap(err, "sshclient: Failed to generate key"),
+		}
+		return
+	}
+
+	pubKey, err := ssh.NewPublicKey(privateKey.Public())
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "sshclient: Failed to parse key"),
+		}
+		return
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		err = &errortypes.ParseError{
+			errors.Wrap(err, "sshclient: Failed to marshal key"),
+		}
+		return
+	}
+
+	block := &pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: keyBytes,
+	}
+
+	err = os.MkdirAll(filepath.Dir(keyPath), 0700)
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "sshclient: Failed to create ssh directory"),
+		}
+		return
+	}
+
+	err = writeNew(keyPath, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "sshclient: Failed to write private key"),
+		}
+		return
+	}
+
+	err = writeNew(keyPath+".pub", ssh.MarshalAuthorizedKey(pubKey), 0644)
+	if err != nil {
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "sshclient: Failed to write public key"),
+		}
+		return
+	}
+
+	return
+}
+
+// Block is written first, ssh uses the first value found for each option
+func writeBlock(path, block string, perm os.FileMode) (err error) {
+	if realPath, e := filepath.EvalSymlinks(path); e == nil {
+		path = realPath
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			err = &errortypes.ReadError{
+				errors.Wrap(err, "sshclient: Failed to read file"),
+			}
+			return
+		}
+		err = nil
+	}
+
+	if stat, e := os.Stat(path); e == nil {
+		perm = stat.Mode().Perm()
+	}
+
+	lines := []string{}
+	inBlock := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if inBlock {
+			if strings.TrimSpace(line) == blockEnd {
+				inBlock = false
+			}
+			continue
+		}
+		if strings.HasPrefix(line, blockStart+" ") &&
+			strings.TrimSpace(line) != blockEnd {
+
+			inBlock = true
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	if inBlock {
+		err = &errortypes.ParseError{
+			errors.Newf("sshclient: Block in '%s' is missing end marker",
+				path),
+		}
+		return
+	}
+
+	output := ""
+	if block != "" {
+		output = strings.TrimRight(block, "\n") + "\n" + blockEnd + "\n"
+	}
+
+	rest := strings.Trim(strings.Join(lines, "\n"), "\n")
+	if rest != "" {
+		output += rest + "\n"
+	}
+
+	tmpPath := path + ".tmp"
+
+	err = ioutil.WriteFile(tmpPath, []byte(output), perm)
+	if err != nil {
+		os.Remove(tmpPath)
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "sshclient: Failed to write file"),
+		}
+		return
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		err = &errortypes.WriteError{
+			errors.Wrap(err, "sshclient: Failed to replace file"),
+		}
+		return
+	}
+
+	return
+}
@@ -0,0 +1,6 @@
+package sshkey
+
+const (
+	User  = "user"
+	Admin = "admin"
+)
@@ -0,0 +1,119 @@
+package sshkey
+
+import (
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/hillrnate/pritunl-zero/authority"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/settings"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+	"time"
+)
+
+type Key struct {
+	Id          bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	UserId      bson.ObjectId `bson:"user_id" json:"user_id"`
+	Label       string        `bson:"label" json:"label"`
+	PubKey      string        `bson:"pub_key" json:"pub_key"`
+	Fingerprint string        `bson:"fingerprint" json:"fingerprint"`
+	KeyType     string        `bson:"key_type" json:"key_type"`
+	Source      string        `bson:"source" json:"source"`
+	Pending     bool          `bson:"pending" json:"pending"`
+	Unapproved  bool          `bson:"unapproved" json:"unapproved"`
+	Timestamp   time.Time     `bson:"timestamp" json:"timestamp"`
+	LastUsed    time.Time     `bson:"last_used" json:"last_used"`
+}
+
+func (k *Key) Validate(db *database.Database) (
+	errData *errortypes.ErrorData, err error) {
+
+	k.PubKey = strings.TrimSpace(k.PubKey)
+	k.Label = strings.TrimSpace(k.Label)
+
+	if len(k.PubKey) > settings.System.SshPubKeyLen {
+		errData = &errortypes.ErrorData{
+			Error:   "public_key_invalid",
+			Message: "Public key is too long",
+		}
+		return
+	}
+
+	pubKey, comment, _, _, e := ssh.ParseAuthorizedKey([]byte(k.PubKey))
+	if e != nil {
+		errData = &errortypes.ErrorData{
+			Error:   "public_key_invalid",
+			Message: "Public key is invalid",
+		}
+		return
+	}
+
+	if _, ok := pubKey.(*ssh.Certificate); ok {
+		errData = &errortypes.ErrorData{
+			Error:   "public_key_invalid",
+			Message: "Public key cannot be a certificate",
+		}
+		return
+	}
+
+	if k.Label == "" {
+		k.Label = comment
+	}
+
+	switch k.Source {
+	case User, Admin:
+		break
+	default:
+		errData = &errortypes.ErrorData{
+			Error:   "source_invalid",
+			Message: "Key source is invalid",
+		}
+		return
+	}
+
+	k.Fingerprint = ssh.FingerprintSHA256(pubKey)
+	k.KeyType = authority.GetKeyAlg(pubKey)
+
+	if k.Timestamp.IsZero() {
+		k.Timestamp = time.Now()
+	}
+
+	return
+}
+
+func (k *Key) Commit(db *database.Database) (err error) {
+	coll := db.SshKeys()
+
+	err = coll.Commit(k.Id, k)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (k *Key) CommitFields(db *database.Database, fields set.Set) (
+	err error) {
+
+	coll := db.SshKeys()
+
+	err = coll.CommitFields(k.Id, k, fields)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func (k *Key) Insert(db *database.Database) (err error) {
+	coll := db.SshKeys()
+
+	err = coll.Insert(k)
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,193 @@
+package sshkey
+
+import (
+	"github.com/hillrnate/pritunl-zero/authority"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/policy"
+	"github.com/hillrnate/pritunl-zero/user"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+func Get(db *database.Database, userId, keyId bson.ObjectId) (
+	key *Key, err error) {
+
+	coll := db.SshKeys()
+	key = &Key{}
+
+	err = coll.FindOne(&bson.M{
+		"_id":     keyId,
+		"user_id": userId,
+	}, key)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func GetFingerprint(db *database.Database, userId bson.ObjectId,
+	fingerprint string) (key *Key, err error) {
+
+	coll := db.SshKeys()
+	key = &Key{}
+
+	err = coll.FindOne(&bson.M{
+		"user_id":     userId,
+		"fingerprint": fingerprint,
+	}, key)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func GetAll(db *database.Database, userId bson.ObjectId) (
+	keys []*Key, err error) {
+
+	coll := db.SshKeys()
+	keys = []*Key{}
+
+	cursor := coll.Find(&bson.M{
+		"user_id": userId,
+	}).Sort("timestamp").Iter()
+
+	key := &Key{}
+	for cursor.Next(key) {
+		keys = append(keys, key)
+		key = &Key{}
+	}
+
+	err = cursor.Close()
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
+
+func IsRegistered(db *database.Database, userId bson.ObjectId,
+	fingerprint string) (registered bool, err error) {
+
+	coll := db.SshKeys()
+
+	err = coll.Update(&bson.M{
+		"user_id":     userId,
+		"fingerprint": fingerprint,
+		"pending":     false,
+		"unapproved": &bson.M{
+			"$ne": true,
+		},
+	}, &bson.M{
+		"$set": &bson.M{
+			"last_used": time.Now(),
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		if _, ok := err.(*database.NotFoundError); ok {
+			err = nil
+		}
+		return
+	}
+
+	registered = true
+
+	return
+}
+
+func getAuthorities(db *database.Database, usr *user.User) (
+	authrs []*authority.Authority, err error) {
+
+	allAuthrs, err := authority.GetAll(db)
+	if err != nil {
+		return
+	}
+
+	authrs = []*authority.Authority{}
+	for _, authr := range allAuthrs {
+		if authr.UserHasAccess(usr) {
+			authrs = append(authrs, authr)
+		}
+	}
+
+	return
+}
+
+func ApprovalRequired(db *database.Database, usr *user.User) (
+	required bool, err error) {
+
+	authrs, err := getAuthorities(db, usr)
+	if err != nil {
+		return
+	}
+
+	for _, authr := range authrs {
+		if authr.KeyApproval {
+			required = true
+			return
+		}
+	}
+
+	return
+}
+
+func GetSecondary(db *database.Database, usr *user.User) (
+	providerId bson.ObjectId, err error) {
+
+	authrs, err := getAuthorities(db, usr)
+	if err != nil {
+		return
+	}
+
+	authrIds := []bson.ObjectId{}
+	for _, authr := range authrs {
+		authrIds = append(authrIds, authr.Id)
+	}
+
+	policies, err := policy.GetAuthoritiesRoles(db, authrIds, usr.Roles)
+	if err != nil {
+		return
+	}
+
+	for _, polcy := range policies {
+		if polcy.AuthoritySecondary != "" {
+			providerId = polcy.AuthoritySecondary
+			return
+		}
+	}
+
+	return
+}
+
+func New(userId bson.ObjectId, label, pubKey, source string) (key *Key) {
+	key = &Key{
+		Id:        bson.NewObjectId(),
+		UserId:    userId,
+		Label:     label,
+		PubKey:    pubKey,
+		Source:    source,
+		Timestamp: time.Now(),
+	}
+
+	return
+}
+
+func Remove(db *database.Database, userId, keyId bson.ObjectId) (
+	err error) {
+
+	coll := db.SshKeys()
+
+	_, err = coll.RemoveAll(&bson.M{
+		"_id":     keyId,
+		"user_id": userId,
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,66 @@
+package task
+
+import (
+	"github.com/hillrnate/pritunl-zero/audit"
+	"github.com/hillrnate/pritunl-zero/authority"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/event"
+)
+
+var authorityRotate = &Task{
+	Name:    "authority_rotate",
+	Hours:   AllHours,
+	Mins:    AllMins,
+	Handler: authorityRotateHandler,
+}
+
+func authorityRotateHandler(db *database.Database) (err error) {
+	authrs, err := authority.GetAll(db)
+	if err != nil {
+		return
+	}
+
+	changed := false
+
+	for _, authr := range authrs {
+		if !authr.RotateReady() {
+			continue
+		}
+
+		err = authr.RotateSwitch()
+		if err != nil {
+			return
+		}
+
+		err = authr.CommitFields(db, authority.RotateFields)
+		if err != nil {
+			return
+		}
+
+		err = audit.NewSystem(
+			db,
+			audit.AuthorityRotateSwitch,
+			audit.Fields{
+				"authority_id":        authr.Id,
+				"key_alg":             authr.Info.KeyAlg,
+				"public_key":          authr.PublicKey,
+				"previous_public_key": authr.PreviousPublicKey,
+			},
+		)
+		if err != nil {
+			return
+		}
+
+		changed = true
+	}
+
+	if changed {
+		event.PublishDispatch(db, "authority.change")
+	}
+
+	return
+}
+
+func init() {
+	register(authorityRotate)
+}
@@ -0,0 +1,34 @@
+package task
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/recording"
+)
+
+var recordingRetention = &Task{
+	Name:    "recording_retention",
+	Hours:   AllHours,
+	Mins:    []int{25},
+	Local:   true,
+	Handler: recordingRetentionHandler,
+}
+
+func recordingRetentionHandler(db *database.Database) (err error) {
+	count, err := recording.RemoveExpired(db)
+	if err != nil {
+		return
+	}
+
+	if count != 0 {
+		logrus.WithFields(logrus.Fields{
+			"count": count,
+		}).Info("task: Removed expired session recordings")
+	}
+
+	return
+}
+
+func init() {
+	register(recordingRetention)
+}
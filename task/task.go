@@ -17,6 +17,7 @@ type Task struct {
 	Hours   []int
 	Mins    []int
 	Retry   bool
+	Local   bool
 	Handler func(*database.Database) error
 }
 
@@ -37,9 +38,13 @@ func (t *Task) run(now time.Time) {
 	db := database.GetDatabase()
 	defer db.Close()
 
+	jobId := fmt.Sprintf("%s-%d", t.Name, now.Unix()-int64(now.Second()))
+	if t.Local {
+		jobId += "-" + node.Self.Id.Hex()
+	}
+
 	job := &Job{
-		Id: fmt.Sprintf(
-			"%s-%d", t.Name, now.Unix()-int64(now.Second())),
+		Id:        jobId,
 		Name:      t.Name,
 		State:     Running,
 		Retry:     t.Retry,
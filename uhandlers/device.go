@@ -0,0 +1,391 @@
+package uhandlers
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/hillrnate/pritunl-zero/audit"
+	"github.com/hillrnate/pritunl-zero/authorizer"
+	"github.com/hillrnate/pritunl-zero/challenge"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/event"
+	"github.com/hillrnate/pritunl-zero/node"
+	"github.com/hillrnate/pritunl-zero/secondary"
+	"github.com/hillrnate/pritunl-zero/ssh"
+	"github.com/hillrnate/pritunl-zero/utils"
+	"time"
+)
+
+type deviceData struct {
+	PublicKey string `json:"public_key"`
+	Expire    int    `json:"expire"`
+}
+
+type deviceCodeData struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationUri         string `json:"verification_uri"`
+	VerificationUriComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type deviceTokenData struct {
+	DeviceCode string `json:"device_code"`
+}
+
+type deviceInfoData struct {
+	UserCode    string    `json:"user_code"`
+	Fingerprint string    `json:"fingerprint"`
+	Expires     time.Time `json:"expires"`
+}
+
+func getVerificationUri(c *gin.Context) string {
+	protocol := node.Self.Protocol
+	if protocol == "" {
+		protocol = "https"
+	}
+
+	domain := node.Self.UserDomain
+	if domain == "" {
+		return protocol + "://" + c.Request.Host
+	}
+
+	if (protocol == "http" && node.Self.Port != 80) ||
+		(protocol == "https" && node.Self.Port != 443) {
+
+		domain += fmt.Sprintf(":%d", node.Self.Port)
+	}
+
+	return protocol + "://" + domain
+}
+
+func deviceGet(c *gin.Context) {
+	authr := c.MustGet("authorizer").(*authorizer.Authorizer)
+
+	query := c.Request.URL.Query()
+	query.Set("device", "1")
+
+	if authr.IsValid() {
+		c.Redirect(302, "/?"+query.Encode())
+	} else {
+		c.Redirect(302, "/login?"+query.Encode())
+	}
+}
+
+func sshDevicePost(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+	data := &deviceData{}
+
+	err := c.Bind(data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	devc, err := challenge.NewDevice(db, data.PublicKey, data.Expire,
+		node.Self.GetRemoteAddr(c.Request))
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	verificationUri := getVerificationUri(c) + "/device"
+
+	resp := &deviceCodeData{
+		DeviceCode:      devc.Id,
+		UserCode:        devc.GetUserCode(),
+		VerificationUri: verificationUri,
+		VerificationUriComplete: verificationUri + "?user-code=" +
+			devc.GetUserCode(),
+		ExpiresIn: int(devc.Expires.Sub(devc.Timestamp).Seconds()),
+		Interval:  devc.Interval,
+	}
+
+	c.JSON(200, resp)
+}
+
+func sshDeviceTokenPost(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+	data := &deviceTokenData{}
+
+	err := c.Bind(data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	devc, err := challenge.GetDevice(db, data.DeviceCode)
+	if err != nil {
+		switch err.(type) {
+		case *database.NotFoundError:
+			errData := &errortypes.ErrorData{
+				Error:   "expired_token",
+				Message: "Device code has expired",
+			}
+			c.JSON(400, errData)
+			break
+		default:
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+
+	valid, err := devc.Poll(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if !valid {
+		errData := &errortypes.ErrorData{
+			Error:   "slow_down",
+			Message: "Polling too frequently",
+		}
+		c.JSON(400, errData)
+		return
+	}
+
+	pending := &errortypes.ErrorData{
+		Error:   "authorization_pending",
+		Message: "Waiting for user authorization",
+	}
+
+	if devc.ChallengeId == "" {
+		c.JSON(400, pending)
+		return
+	}
+
+	chal, err := challenge.GetChallenge(db, devc.ChallengeId)
+	if err != nil {
+		switch err.(type) {
+		case *database.NotFoundError:
+			errData := &errortypes.ErrorData{
+				Error:   "expired_token",
+				Message: "Device authorization has expired",
+			}
+			c.JSON(400, errData)
+			break
+		default:
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+
+	switch chal.State {
+	case ssh.Approved:
+		resp, err := getCertificateData(db, devc.Id, chal.CertificateId)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		err = devc.Remove(db)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		c.JSON(200, resp)
+		break
+	case ssh.Unavailable:
+		err = devc.Remove(db)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		errData := &errortypes.ErrorData{
+			Error: "certificate_unavailable",
+			Message: "Cerification was approved but no " +
+				"certificates are available",
+		}
+		c.JSON(412, errData)
+		break
+	case ssh.Denied:
+		err = devc.Remove(db)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		errData := &errortypes.ErrorData{
+			Error:   "access_denied",
+			Message: "Device authorization was denied",
+		}
+		if chal.ErrorData != nil {
+			errData.Message = chal.ErrorData.Message
+		}
+		c.JSON(400, errData)
+		break
+	default:
+		c.JSON(400, pending)
+	}
+}
+
+func sshDeviceGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+
+	devc, err := challenge.GetDeviceUserCode(db, c.Param("user_code"))
+	if err != nil {
+		switch err.(type) {
+		case *database.NotFoundError:
+			utils.AbortWithStatus(c, 404)
+			break
+		default:
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+
+	resp := &deviceInfoData{
+		UserCode:    devc.GetUserCode(),
+		Fingerprint: ssh.Fingerprint(devc.PubKey),
+		Expires:     devc.Expires,
+	}
+
+	c.JSON(200, resp)
+}
+
+func sshDevicePut(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+	authr := c.MustGet("authorizer").(*authorizer.Authorizer)
+
+	usr, err := authr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	devc, err := challenge.GetDeviceUserCode(db, c.Param("user_code"))
+	if err != nil {
+		switch err.(type) {
+		case *database.NotFoundError:
+			utils.AbortWithStatus(c, 404)
+			break
+		default:
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+
+	chal, err := devc.GetChallenge(db)
+	if err != nil {
+		switch err.(type) {
+		case *database.NotFoundError:
+			utils.AbortWithStatus(c, 404)
+			break
+		default:
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+
+	secProviderId, err, errData := chal.Approve(db, usr, c.Request, false)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if secProviderId != "" {
+		secd, err := secondary.NewChallenge(
+			db, usr.Id, secondary.Authority, chal.Id, secProviderId)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		data, err := secd.GetData()
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		c.JSON(201, data)
+		return
+	}
+
+	err = audit.New(
+		db,
+		c.Request,
+		usr.Id,
+		audit.SshApprove,
+		audit.Fields{
+			"ssh_key": chal.PubKey,
+			"device":  true,
+		},
+	)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.Publish(db, "ssh_challenge", chal.Id)
+
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
+	c.Status(200)
+}
+
+func sshDeviceDelete(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+	authr := c.MustGet("authorizer").(*authorizer.Authorizer)
+
+	usr, err := authr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	devc, err := challenge.GetDeviceUserCode(db, c.Param("user_code"))
+	if err != nil {
+		switch err.(type) {
+		case *database.NotFoundError:
+			utils.AbortWithStatus(c, 404)
+			break
+		default:
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+
+	chal, err := devc.GetChallenge(db)
+	if err != nil {
+		switch err.(type) {
+		case *database.NotFoundError:
+			utils.AbortWithStatus(c, 404)
+			break
+		default:
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+
+	err = audit.New(
+		db,
+		c.Request,
+		usr.Id,
+		audit.SshDeny,
+		audit.Fields{
+			"ssh_key": chal.PubKey,
+			"device":  true,
+		},
+	)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = chal.Deny(db, usr)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.Publish(db, "ssh_challenge", chal.Id)
+
+	c.Status(200)
+}
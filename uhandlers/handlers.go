@@ -62,9 +62,23 @@ func Register(engine *gin.Engine) {
 	csrfGroup.PUT("/ssh/validate/:ssh_token", sshValidatePut)
 	csrfGroup.DELETE("/ssh/validate/:ssh_token", sshValidateDelete)
 	csrfGroup.PUT("/ssh/secondary", sshSecondaryPut)
+	authGroup.GET("/ssh/config", sshConfigGet)
+	dbGroup.PUT("/ssh/config", sshConfigPut)
+	authGroup.GET("/ssh/keys", sshKeysGet)
+	csrfGroup.POST("/ssh/keys", sshKeyPost)
+	csrfGroup.PUT("/ssh/keys/secondary", sshKeySecondaryPut)
+	csrfGroup.DELETE("/ssh/keys/:key_id", sshKeyDelete)
+	authGroup.GET("/ssh/config/:file", sshConfigGet)
 	dbGroup.POST("/ssh/challenge", sshChallengePost)
 	dbGroup.PUT("/ssh/challenge", sshChallengePut)
 	dbGroup.POST("/ssh/host", sshHostPost)
+	sessGroup.GET("/device", deviceGet)
+	dbGroup.POST("/ssh/device", sshDevicePost)
+	dbGroup.POST("/ssh/device/token", sshDeviceTokenPost)
+	csrfGroup.GET("/ssh/device/:user_code", sshDeviceGet)
+	csrfGroup.PUT("/ssh/device/:user_code", sshDevicePut)
+	csrfGroup.DELETE("/ssh/device/:user_code", sshDeviceDelete)
+	dbGroup.GET("/ssh/principals", sshPrincipalsGet)
 
 	engine.GET("/robots.txt", middlewear.RobotsGet)
 
@@ -490,6 +490,16 @@ func keybaseChallengePut(c *gin.Context) {
 
 	cert, errData, err := chal.NewCertificate(db, c.Request)
 	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if errData != nil {
+		if chal.State == ssh.Denied {
+			c.JSON(401, errData)
+		} else {
+			c.JSON(412, errData)
+		}
 		return
 	}
 
@@ -570,6 +580,15 @@ func keybaseSecondaryPut(c *gin.Context) {
 		return
 	}
 
+	if errData != nil {
+		if chal.State == ssh.Denied {
+			c.JSON(401, errData)
+		} else {
+			c.JSON(412, errData)
+		}
+		return
+	}
+
 	resp := &keybaseCertificateData{
 		Token:                  chal.Id,
 		Hosts:                  cert.Hosts,
@@ -3,15 +3,20 @@ package uhandlers
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/hillrnate/pritunl-zero/audit"
+	"github.com/hillrnate/pritunl-zero/authority"
 	"github.com/hillrnate/pritunl-zero/authorizer"
 	"github.com/hillrnate/pritunl-zero/challenge"
 	"github.com/hillrnate/pritunl-zero/database"
 	"github.com/hillrnate/pritunl-zero/errortypes"
 	"github.com/hillrnate/pritunl-zero/event"
+	"github.com/hillrnate/pritunl-zero/revocation"
 	"github.com/hillrnate/pritunl-zero/secondary"
 	"github.com/hillrnate/pritunl-zero/ssh"
+	"github.com/hillrnate/pritunl-zero/user"
 	"github.com/hillrnate/pritunl-zero/utils"
+	"gopkg.in/mgo.v2/bson"
 	"regexp"
+	"strings"
 	"time"
 )
 
@@ -22,13 +27,59 @@ var (
 type sshValidateData struct {
 	Token     string `json:"token"`
 	PublicKey string `json:"public_key,omitempty"`
+	Expire    int    `json:"expire,omitempty"`
 }
 
 type sshCertificateData struct {
 	Token                  string      `json:"token"`
 	Certificates           []string    `json:"certificates"`
+	CertificatesInfo       []*ssh.Info `json:"certificates_info"`
 	CertificateAuthorities []string    `json:"certificate_authorities"`
 	Hosts                  []*ssh.Host `json:"hosts"`
+	Config                 *ssh.Config `json:"config"`
+	ConfigToken            string      `json:"config_token"`
+}
+
+func getCertificateData(db *database.Database, token string,
+	certId bson.ObjectId) (resp *sshCertificateData, err error) {
+
+	cert, err := ssh.GetCertificate(db, certId)
+	if err != nil {
+		return
+	}
+
+	usr, err := user.Get(db, cert.UserId)
+	if err != nil {
+		return
+	}
+
+	conf, err := ssh.GetConfig(db, usr)
+	if err != nil {
+		return
+	}
+
+	resp = &sshCertificateData{
+		Token:                  token,
+		Hosts:                  cert.Hosts,
+		Certificates:           cert.Certificates,
+		CertificatesInfo:       cert.CertificatesInfo,
+		CertificateAuthorities: cert.CertificateAuthorities,
+		Config:                 conf,
+		ConfigToken:            cert.ConfigToken,
+	}
+
+	return
+}
+
+func configNotModified(c *gin.Context, conf *ssh.Config) bool {
+	etag := `"` + conf.Version + `"`
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(304)
+		return true
+	}
+
+	return false
 }
 
 func sshGet(c *gin.Context) {
@@ -310,7 +361,7 @@ func sshChallengePut(c *gin.Context) {
 	update := func() bool {
 		switch chal.State {
 		case ssh.Approved:
-			cert, err := ssh.GetCertificate(db, chal.CertificateId)
+			resp, err := getCertificateData(db, token, chal.CertificateId)
 			if err != nil {
 				switch err.(type) {
 				case *database.NotFoundError:
@@ -322,13 +373,6 @@ func sshChallengePut(c *gin.Context) {
 				return true
 			}
 
-			resp := &sshCertificateData{
-				Token:                  token,
-				Hosts:                  cert.Hosts,
-				Certificates:           cert.Certificates,
-				CertificateAuthorities: cert.CertificateAuthorities,
-			}
-
 			c.JSON(200, resp)
 
 			return true
@@ -341,7 +385,11 @@ func sshChallengePut(c *gin.Context) {
 			c.JSON(412, errData)
 			return true
 		case ssh.Denied:
-			c.Status(401)
+			if chal.ErrorData != nil {
+				c.JSON(401, chal.ErrorData)
+			} else {
+				c.Status(401)
+			}
 			return true
 		}
 
@@ -395,7 +443,8 @@ func sshChallengePost(c *gin.Context) {
 		return
 	}
 
-	chal, err := challenge.NewChallenge(db, data.PublicKey)
+	chal, err := challenge.NewChallenge(
+		db, data.PublicKey, data.Expire, "")
 	if err != nil {
 		switch err.(type) {
 		case *database.NotFoundError:
@@ -414,15 +463,127 @@ func sshChallengePost(c *gin.Context) {
 	c.JSON(200, resp)
 }
 
+type sshConfigData struct {
+	Token string `json:"token"`
+}
+
+func sshConfigGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+	authr := c.MustGet("authorizer").(*authorizer.Authorizer)
+
+	usr, err := authr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	conf, err := ssh.GetConfig(db, usr)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if configNotModified(c, conf) {
+		return
+	}
+
+	switch c.Param("file") {
+	case "":
+		c.JSON(200, conf)
+		break
+	case "ssh_config":
+		c.String(200, conf.SshConfig)
+		break
+	case "known_hosts":
+		c.String(200, conf.KnownHosts)
+		break
+	default:
+		utils.AbortWithStatus(c, 404)
+	}
+}
+
+// Token is valid while a certificate issued with it is active
+func sshConfigPut(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+	data := &sshConfigData{}
+
+	err := c.Bind(data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if data.Token == "" {
+		utils.AbortWithStatus(c, 401)
+		return
+	}
+
+	cert, err := ssh.GetConfigCertificate(db, data.Token)
+	if err != nil {
+		switch err.(type) {
+		case *database.NotFoundError:
+			utils.AbortWithStatus(c, 401)
+			break
+		default:
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+
+	active, err := revocation.HasActive(
+		db, cert.AuthorityIds, cert.Certificates)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if !active {
+		utils.AbortWithStatus(c, 401)
+		return
+	}
+
+	usr, err := user.Get(db, cert.UserId)
+	if err != nil {
+		switch err.(type) {
+		case *database.NotFoundError:
+			utils.AbortWithStatus(c, 401)
+			break
+		default:
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+
+	if usr.Disabled {
+		utils.AbortWithStatus(c, 401)
+		return
+	}
+
+	conf, err := ssh.GetConfig(db, usr)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if configNotModified(c, conf) {
+		return
+	}
+
+	c.JSON(200, conf)
+}
+
 type sshHostData struct {
-	Hostname  string   `json:"hostname"`
-	Port      int      `json:"port"`
-	Tokens    []string `json:"tokens"`
-	PublicKey string   `json:"public_key"`
+	Hostname   string   `json:"hostname"`
+	Port       int      `json:"port"`
+	Principals []string `json:"principals"`
+	Tokens     []string `json:"tokens"`
+	PublicKey  string   `json:"public_key"`
 }
 
 type sshHostCertificateData struct {
 	Certificates []string `json:"certificates"`
+	PublicKeys   []string `json:"public_keys"`
+	Errors       []string `json:"errors"`
 }
 
 func sshHostPost(c *gin.Context) {
@@ -437,8 +598,8 @@ func sshHostPost(c *gin.Context) {
 
 	hostname := domainRe.ReplaceAllString(data.Hostname, "")
 
-	cert, errData, err := ssh.NewHostCertificate(db, hostname,
-		data.Port, data.Tokens, c.Request, data.PublicKey)
+	cert, reasons, errData, err := ssh.NewHostCertificate(db, hostname,
+		data.Port, data.Principals, data.Tokens, c.Request, data.PublicKey)
 	if err != nil {
 		switch err.(type) {
 		case *database.NotFoundError:
@@ -455,9 +616,51 @@ func sshHostPost(c *gin.Context) {
 		return
 	}
 
+	authrs, err := authority.GetMulti(db, cert.AuthorityIds)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	pubKeys := []string{}
+	for _, authr := range authrs {
+		pubKeys = append(pubKeys, authr.GetPublicKeys()...)
+	}
+
 	resp := &sshHostCertificateData{
 		Certificates: cert.Certificates,
+		PublicKeys:   pubKeys,
+		Errors:       reasons,
 	}
 
 	c.JSON(200, resp)
 }
+
+func sshPrincipalsGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+
+	token := strings.TrimSpace(strings.TrimPrefix(
+		c.GetHeader("Authorization"), "Bearer "))
+	hostname := domainRe.ReplaceAllString(c.Query("hostname"), "")
+	account := c.Query("account")
+	keyId := c.Query("key_id")
+
+	principals, errData, err := ssh.GetAuthorizedPrincipals(db, hostname,
+		account, keyId, []string{token}, c.Request)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if errData != nil {
+		c.JSON(401, errData)
+		return
+	}
+
+	output := ""
+	for _, principal := range principals {
+		output += principal + "\n"
+	}
+
+	c.String(200, output)
+}
@@ -0,0 +1,309 @@
+package uhandlers
+
+import (
+	"github.com/dropbox/godropbox/container/set"
+	"github.com/gin-gonic/gin"
+	"github.com/hillrnate/pritunl-zero/audit"
+	"github.com/hillrnate/pritunl-zero/authorizer"
+	"github.com/hillrnate/pritunl-zero/database"
+	"github.com/hillrnate/pritunl-zero/errortypes"
+	"github.com/hillrnate/pritunl-zero/event"
+	"github.com/hillrnate/pritunl-zero/secondary"
+	"github.com/hillrnate/pritunl-zero/sshkey"
+	"github.com/hillrnate/pritunl-zero/utils"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type sshKeyData struct {
+	Label     string `json:"label"`
+	PublicKey string `json:"public_key"`
+}
+
+func sshKeysGet(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+	authr := c.MustGet("authorizer").(*authorizer.Authorizer)
+
+	usr, err := authr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	keys, err := sshkey.GetAll(db, usr.Id)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	c.JSON(200, keys)
+}
+
+func sshKeyPost(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+	authr := c.MustGet("authorizer").(*authorizer.Authorizer)
+	data := &sshKeyData{}
+
+	err := c.Bind(data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	usr, err := authr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	key := sshkey.New(usr.Id, data.Label, data.PublicKey, sshkey.User)
+
+	errData, err := key.Validate(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	if errData != nil {
+		c.JSON(400, errData)
+		return
+	}
+
+	secProviderId, err := sshkey.GetSecondary(db, usr)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+	key.Pending = secProviderId != ""
+
+	key.Unapproved, err = sshkey.ApprovalRequired(db, usr)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = key.Insert(db)
+	if err != nil {
+		if _, ok := err.(*database.DuplicateKeyError); !ok {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		existing, err := sshkey.GetFingerprint(
+			db, usr.Id, key.Fingerprint)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		if !existing.Pending {
+			errData := &errortypes.ErrorData{
+				Error:   "ssh_key_exists",
+				Message: "SSH key is already registered",
+			}
+			c.JSON(400, errData)
+			return
+		}
+
+		existing.Label = key.Label
+		existing.Pending = key.Pending
+		existing.Unapproved = key.Unapproved
+		err = existing.CommitFields(db,
+			set.NewSet("label", "pending", "unapproved"))
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		key = existing
+	}
+
+	if key.Pending {
+		secd, err := secondary.NewChallenge(db, usr.Id, secondary.SshKey,
+			key.Id.Hex(), secProviderId)
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		data, err := secd.GetData()
+		if err != nil {
+			utils.AbortWithError(c, 500, err)
+			return
+		}
+
+		event.PublishDispatch(db, "sshkey.change")
+
+		c.JSON(201, data)
+		return
+	}
+
+	err = audit.New(
+		db,
+		c.Request,
+		usr.Id,
+		audit.SshKeyRegister,
+		audit.Fields{
+			"key_id":      key.Id,
+			"fingerprint": key.Fingerprint,
+			"key_type":    key.KeyType,
+			"source":      key.Source,
+			"unapproved":  key.Unapproved,
+		},
+	)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "sshkey.change")
+
+	c.JSON(200, key)
+}
+
+func sshKeySecondaryPut(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+	authr := c.MustGet("authorizer").(*authorizer.Authorizer)
+	data := &sshSecondaryData{}
+
+	err := c.Bind(data)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	usr, err := authr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	secd, err := secondary.Get(db, data.Token, secondary.SshKey)
+	if err != nil {
+		if _, ok := err.(*database.NotFoundError); ok {
+			errData := &errortypes.ErrorData{
+				Error:   "secondary_expired",
+				Message: "Two-factor authentication has expired",
+			}
+			c.JSON(401, errData)
+		} else {
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+
+	if secd.UserId != usr.Id || !bson.IsObjectIdHex(secd.ChallengeId) {
+		utils.AbortWithStatus(c, 401)
+		return
+	}
+
+	errData, err := secd.Handle(db, c.Request, data.Factor, data.Passcode)
+	if err != nil {
+		if _, ok := err.(*secondary.IncompleteError); ok {
+			c.Status(201)
+		} else {
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+
+	if errData != nil {
+		c.JSON(401, errData)
+		return
+	}
+
+	key, err := sshkey.Get(db, usr.Id, bson.ObjectIdHex(secd.ChallengeId))
+	if err != nil {
+		switch err.(type) {
+		case *database.NotFoundError:
+			utils.AbortWithStatus(c, 404)
+			break
+		default:
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+
+	key.Pending = false
+	err = key.CommitFields(db, set.NewSet("pending"))
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = audit.New(
+		db,
+		c.Request,
+		usr.Id,
+		audit.SshKeyRegister,
+		audit.Fields{
+			"key_id":      key.Id,
+			"fingerprint": key.Fingerprint,
+			"key_type":    key.KeyType,
+			"source":      key.Source,
+			"unapproved":  key.Unapproved,
+			"secondary":   true,
+		},
+	)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "sshkey.change")
+
+	c.JSON(200, key)
+}
+
+func sshKeyDelete(c *gin.Context) {
+	db := c.MustGet("db").(*database.Database)
+	authr := c.MustGet("authorizer").(*authorizer.Authorizer)
+
+	keyId, ok := utils.ParseObjectId(c.Param("key_id"))
+	if !ok {
+		utils.AbortWithStatus(c, 400)
+		return
+	}
+
+	usr, err := authr.GetUser(db)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	key, err := sshkey.Get(db, usr.Id, keyId)
+	if err != nil {
+		switch err.(type) {
+		case *database.NotFoundError:
+			utils.AbortWithStatus(c, 404)
+			break
+		default:
+			utils.AbortWithError(c, 500, err)
+		}
+		return
+	}
+
+	err = sshkey.Remove(db, usr.Id, key.Id)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	err = audit.New(
+		db,
+		c.Request,
+		usr.Id,
+		audit.SshKeyRemove,
+		audit.Fields{
+			"key_id":      key.Id,
+			"fingerprint": key.Fingerprint,
+		},
+	)
+	if err != nil {
+		utils.AbortWithError(c, 500, err)
+		return
+	}
+
+	event.PublishDispatch(db, "sshkey.change")
+
+	c.Status(200)
+}
@@ -194,6 +194,18 @@ func Remove(db *database.Database, userIds []bson.ObjectId) (
 		return
 	}
 
+	coll = db.SshKeys()
+
+	_, err = coll.RemoveAll(&bson.M{
+		"user_id": &bson.M{
+			"$in": userIds,
+		},
+	})
+	if err != nil {
+		err = database.ParseError(err)
+		return
+	}
+
 	coll = db.Users()
 
 	_, err = coll.RemoveAll(&bson.M{
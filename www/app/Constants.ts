@@ -43,6 +43,18 @@ export const operatingSystems: {[key: string]: string} = {
 	kindle: 'Kindle',
 };
 
+export const sshKeyTypes: {[key: string]: string} = {
+	'ssh-ed25519': 'ED25519',
+	'sk-ssh-ed25519@openssh.com': 'ED25519 Security Key',
+	'ecdsa-sha2-nistp256': 'EC P256',
+	'ecdsa-sha2-nistp384': 'EC P384',
+	'ecdsa-sha2-nistp521': 'EC P521',
+	'sk-ecdsa-sha2-nistp256@openssh.com': 'EC P256 Security Key',
+	'ssh-rsa:2048': 'RSA 2048 or larger',
+	'ssh-rsa:3072': 'RSA 3072 or larger',
+	'ssh-rsa:4096': 'RSA 4096 or larger',
+};
+
 export const browsers: {[key: string]: string} = {
 	chrome: 'Chrome',
 	chrome_mobile: 'Chrome Mobile',
@@ -275,7 +275,7 @@ export default class Authority extends React.Component<Props, State> {
 		for (let token of this.props.authority.host_tokens || []) {
 			tokens.push(
 				<PageInputButton
-					key={token}
+					key={token.id}
 					buttonClass="pt-minimal pt-intent-danger pt-icon-remove"
 					type="text"
 					hidden={!authority.host_certificates}
@@ -284,10 +284,10 @@ export default class Authority extends React.Component<Props, State> {
 					listStyle={true}
 					buttonDisabled={this.state.changed}
 					buttonConfirm={true}
-					value={token}
+					value={token.token}
 					onSubmit={(): void => {
 						AuthorityActions.deleteToken(
-								this.props.authority.id, token).then((): void => {
+								this.props.authority.id, token.id).then((): void => {
 							this.setState({
 								...this.state,
 								disabled: false,
@@ -339,6 +339,30 @@ export default class Authority extends React.Component<Props, State> {
 							this.set('key', val);
 						}}
 					/>
+					<PageSwitch
+						label="Registered keys only"
+						help="Only certify SSH keys that the user has registered."
+						checked={authority.registered_keys}
+						onToggle={(): void => {
+							this.toggle('registered_keys');
+						}}
+					/>
+					<PageSwitch
+						label="Key registration approval"
+						help="Require an administrator to approve SSH keys registered by users."
+						checked={authority.key_approval}
+						onToggle={(): void => {
+							this.toggle('key_approval');
+						}}
+					/>
+					<PageSwitch
+						label="Require session recording"
+						help="Refuse bastion port forwarding to hosts of this authority, hosts can only be accessed with recorded bastion terminal sessions."
+						checked={authority.recording_required}
+						onToggle={(): void => {
+							this.toggle('recording_required');
+						}}
+					/>
 					<PageSwitch
 						label="Host certificates"
 						help="Allow servers to validate and sign SSH host keys."
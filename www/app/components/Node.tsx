@@ -3,10 +3,12 @@ import * as React from 'react';
 import * as NodeTypes from '../types/NodeTypes';
 import * as ServiceTypes from '../types/ServiceTypes';
 import * as CertificateTypes from '../types/CertificateTypes';
+import * as AuthorityTypes from '../types/AuthorityTypes';
 import * as NodeActions from '../actions/NodeActions';
 import * as MiscUtils from '../utils/MiscUtils';
 import CertificatesStore from '../stores/CertificatesStore';
 import ServicesStore from '../stores/ServicesStore';
+import AuthoritiesStore from '../stores/AuthoritiesStore';
 import PageInput from './PageInput';
 import PageSwitch from './PageSwitch';
 import PageInputSwitch from './PageInputSwitch';
@@ -20,6 +22,7 @@ interface Props {
 	node: NodeTypes.NodeRo;
 	services: ServiceTypes.ServicesRo;
 	certificates: CertificateTypes.CertificatesRo;
+	authorities: AuthorityTypes.AuthoritiesRo;
 }
 
 interface State {
@@ -29,6 +32,7 @@ interface State {
 	node: NodeTypes.Node;
 	addService: string;
 	addCert: string;
+	addAuthority: string;
 	forwardedChecked: boolean;
 }
 
@@ -85,6 +89,7 @@ export default class Node extends React.Component<Props, State> {
 			node: null,
 			addService: null,
 			addCert: null,
+			addAuthority: null,
 			forwardedChecked: false,
 		};
 	}
@@ -325,6 +330,78 @@ export default class Node extends React.Component<Props, State> {
 		});
 	}
 
+	onAddAuthority = (): void => {
+		let node: NodeTypes.Node;
+
+		if (!this.state.addAuthority && !this.props.authorities.length) {
+			return;
+		}
+
+		let authorityId = this.state.addAuthority ||
+			this.props.authorities[0].id;
+
+		if (this.state.changed) {
+			node = {
+				...this.state.node,
+			};
+		} else {
+			node = {
+				...this.props.node,
+			};
+		}
+
+		let authorities = [
+			...(node.bastion_authorities || []),
+		];
+
+		if (authorities.indexOf(authorityId) === -1) {
+			authorities.push(authorityId);
+		}
+
+		authorities.sort();
+
+		node.bastion_authorities = authorities;
+
+		this.setState({
+			...this.state,
+			changed: true,
+			node: node,
+		});
+	}
+
+	onRemoveAuthority = (authorityId: string): void => {
+		let node: NodeTypes.Node;
+
+		if (this.state.changed) {
+			node = {
+				...this.state.node,
+			};
+		} else {
+			node = {
+				...this.props.node,
+			};
+		}
+
+		let authorities = [
+			...(node.bastion_authorities || []),
+		];
+
+		let i = authorities.indexOf(authorityId);
+		if (i === -1) {
+			return;
+		}
+
+		authorities.splice(i, 1);
+
+		node.bastion_authorities = authorities;
+
+		this.setState({
+			...this.state,
+			changed: true,
+			node: node,
+		});
+	}
+
 	render(): JSX.Element {
 		let node: NodeTypes.Node = this.state.node || this.props.node;
 		let active = node.requests_min !== 0 || node.memory !== 0 ||
@@ -389,6 +466,47 @@ export default class Node extends React.Component<Props, State> {
 			);
 		}
 
+		let authorities: JSX.Element[] = [];
+		for (let authorityId of (node.bastion_authorities || [])) {
+			let authority = AuthoritiesStore.authority(authorityId);
+			if (!authority) {
+				continue;
+			}
+
+			authorities.push(
+				<div
+					className="pt-tag pt-tag-removable pt-intent-primary"
+					style={css.item}
+					key={authority.id}
+				>
+					{authority.name}
+					<button
+						className="pt-tag-remove"
+						onMouseUp={(): void => {
+							this.onRemoveAuthority(authority.id);
+						}}
+					/>
+				</div>,
+			);
+		}
+
+		let authoritiesSelect: JSX.Element[] = [];
+		if (this.props.authorities.length) {
+			for (let authority of this.props.authorities) {
+				authoritiesSelect.push(
+					<option key={authority.id} value={authority.id}>
+						{authority.name}
+					</option>,
+				);
+			}
+		} else {
+			authoritiesSelect.push(<option key="null" value="">None</option>);
+		}
+
+		let webType = (node.type || '').split('_').filter(
+			(typ: string): boolean => typ !== 'bastion').join('_');
+		let bastion = node.type.indexOf('bastion') !== -1;
+
 		let certificatesSelect: JSX.Element[] = [];
 		if (this.props.certificates.length) {
 			for (let certificate of this.props.certificates) {
@@ -449,9 +567,17 @@ export default class Node extends React.Component<Props, State> {
 							this.toggleType('proxy');
 						}}
 					/>
+					<PageSwitch
+						label="Bastion"
+						help="Runs an SSH bastion server that forwards connections for users with certificates from the bastion authorities."
+						checked={bastion}
+						onToggle={(): void => {
+							this.toggleType('bastion');
+						}}
+					/>
 					<PageInput
-						hidden={node.type.indexOf('_') === -1 ||
-							node.type.indexOf('management') === -1}
+						hidden={webType.indexOf('_') === -1 ||
+							webType.indexOf('management') === -1}
 						label="Management Domain"
 						help="Domain that will be used to access the management interface."
 						type="text"
@@ -462,8 +588,8 @@ export default class Node extends React.Component<Props, State> {
 						}}
 					/>
 					<PageInput
-						hidden={node.type.indexOf('_') === -1 ||
-							node.type.indexOf('user') === -1}
+						hidden={webType.indexOf('_') === -1 ||
+							webType.indexOf('user') === -1}
 						label="User Domain"
 						help="Domain that will be used to access the user interface."
 						type="text"
@@ -531,6 +657,57 @@ export default class Node extends React.Component<Props, State> {
 					>
 						{servicesSelect}
 					</PageSelectButton>
+					<PageInput
+						hidden={!bastion}
+						label="Bastion Port"
+						help="Port the SSH bastion server will listen on, the port must not be used by the SSH server of the node."
+						type="text"
+						placeholder="Bastion port"
+						value={node.bastion_port || 2222}
+						onChange={(val): void => {
+							this.set('bastion_port', parseInt(val, 10));
+						}}
+					/>
+					<label
+						className="pt-label"
+						style={css.label}
+						hidden={!bastion}
+					>
+						Bastion Authorities
+						<Help
+							title="Bastion Authorities"
+							content="Authorities that the bastion will accept user certificates from. Users can only connect to hosts in the host domain of the authority that signed the certificate. The certificate principals must include the username used to connect to the bastion."
+						/>
+						<div>
+							{authorities}
+						</div>
+					</label>
+					<PageSelectButton
+						hidden={!bastion}
+						label="Add Authority"
+						value={this.state.addAuthority}
+						disabled={!this.props.authorities.length}
+						buttonClass="pt-intent-success"
+						onChange={(val: string): void => {
+							this.setState({
+								...this.state,
+								addAuthority: val,
+							});
+						}}
+						onSubmit={this.onAddAuthority}
+					>
+						{authoritiesSelect}
+					</PageSelectButton>
+					<PageInput
+						hidden={!bastion || !node.bastion_public_key}
+						readOnly={true}
+						autoSelect={true}
+						label="Bastion Host Key"
+						help="Public host key of the bastion server, add this key to the known hosts file of clients to verify the bastion."
+						type="text"
+						placeholder="Bastion host key"
+						value={node.bastion_public_key}
+					/>
 				</div>
 				<div style={css.group}>
 					<PageInfo
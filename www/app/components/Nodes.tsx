@@ -3,12 +3,15 @@ import * as React from 'react';
 import * as NodeTypes from '../types/NodeTypes';
 import * as ServiceTypes from '../types/ServiceTypes';
 import * as CertificateTypes from '../types/CertificateTypes';
+import * as AuthorityTypes from '../types/AuthorityTypes';
 import NodesStore from '../stores/NodesStore';
 import ServicesStore from '../stores/ServicesStore';
 import CertificatesStore from '../stores/CertificatesStore';
+import AuthoritiesStore from '../stores/AuthoritiesStore';
 import * as NodeActions from '../actions/NodeActions';
 import * as ServiceActions from '../actions/ServiceActions';
 import * as CertificateActions from '../actions/CertificateActions';
+import * as AuthorityActions from '../actions/AuthorityActions';
 import Node from './Node';
 import Page from './Page';
 import PageHeader from './PageHeader';
@@ -17,6 +20,7 @@ interface State {
 	nodes: NodeTypes.NodesRo;
 	services: ServiceTypes.ServicesRo;
 	certificates: CertificateTypes.CertificatesRo;
+	authorities: AuthorityTypes.AuthoritiesRo;
 	disabled: boolean;
 }
 
@@ -36,6 +40,7 @@ export default class Nodes extends React.Component<{}, State> {
 			nodes: NodesStore.nodes,
 			services: ServicesStore.services,
 			certificates: CertificatesStore.certificates,
+			authorities: AuthoritiesStore.authorities,
 			disabled: false,
 		};
 	}
@@ -44,15 +49,18 @@ export default class Nodes extends React.Component<{}, State> {
 		NodesStore.addChangeListener(this.onChange);
 		ServicesStore.addChangeListener(this.onChange);
 		CertificatesStore.addChangeListener(this.onChange);
+		AuthoritiesStore.addChangeListener(this.onChange);
 		NodeActions.sync();
 		ServiceActions.sync();
 		CertificateActions.sync();
+		AuthorityActions.sync();
 	}
 
 	componentWillUnmount(): void {
 		NodesStore.removeChangeListener(this.onChange);
 		ServicesStore.removeChangeListener(this.onChange);
 		CertificatesStore.removeChangeListener(this.onChange);
+		AuthoritiesStore.removeChangeListener(this.onChange);
 	}
 
 	onChange = (): void => {
@@ -61,6 +69,7 @@ export default class Nodes extends React.Component<{}, State> {
 			nodes: NodesStore.nodes,
 			services: ServicesStore.services,
 			certificates: CertificatesStore.certificates,
+			authorities: AuthoritiesStore.authorities,
 		});
 	}
 
@@ -73,6 +82,7 @@ export default class Nodes extends React.Component<{}, State> {
 				node={node}
 				services={this.state.services}
 				certificates={this.state.certificates}
+				authorities={this.state.authorities}
 			/>);
 		});
 
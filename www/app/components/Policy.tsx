@@ -521,6 +521,9 @@ export default class Policy extends React.Component<Props, State> {
 		let location = policy.rules.location || {
 			type: 'location',
 		};
+		let sshKeyTypes = policy.rules.ssh_key_types || {
+			type: 'ssh_key_types',
+		};
 
 		let providerIds: string[] = [];
 		let adminProviders: JSX.Element[] = [];
@@ -836,6 +839,12 @@ export default class Policy extends React.Component<Props, State> {
 							this.setRule('browser', val);
 						}}
 					/>
+					<PolicyRule
+						rule={sshKeyTypes}
+						onChange={(val): void => {
+							this.setRule('ssh_key_types', val);
+						}}
+					/>
 				</div>
 			</div>
 			<PageSave
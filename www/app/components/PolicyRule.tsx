@@ -100,6 +100,11 @@ export default class PolicyRule extends React.Component<Props, State> {
 				selectLabel = 'Location policies';
 				options = Constants.locations;
 				break;
+			case 'ssh_key_types':
+				label = 'Permitted SSH Key Types';
+				selectLabel = 'SSH key type policies';
+				options = Constants.sshKeyTypes;
+				break;
 		}
 
 		let optionsSelect: JSX.Element[] = [];
@@ -150,7 +155,7 @@ export default class PolicyRule extends React.Component<Props, State> {
 				label="Disabled user on failure"
 				help="This will disable the user when the policy check fails. It is generally only useful for the location check to disable a user account when an authentication occurs from a foreign country. It is important to consider that the policy check is the last check that occurs during authentication. An authentication attempt with an incorrect password from a foreign country would not trigger a policy failure or disable the user."
 				checked={rule.disable}
-				hidden={rule.values == null}
+				hidden={rule.values == null || rule.type === 'ssh_key_types'}
 				onToggle={(): void => {
 					let state = this.clone();
 					state.disable = !state.disable;
@@ -6,6 +6,17 @@ export interface Info {
 	key_alg?: string;
 }
 
+export interface HostToken {
+	id?: string;
+	token?: string;
+	name?: string;
+	timestamp?: string;
+	expires?: string;
+	match?: string;
+	count?: number;
+	last_used?: string;
+}
+
 export interface Authority {
 	id: string;
 	name?: string;
@@ -20,7 +31,10 @@ export interface Authority {
 	host_proxy?: string;
 	host_certificates?: boolean;
 	strict_host_checking?: boolean;
-	host_tokens?: string[];
+	registered_keys?: boolean;
+	key_approval?: boolean;
+	recording_required?: boolean;
+	host_tokens?: HostToken[];
 }
 
 export type Authorities = Authority[];
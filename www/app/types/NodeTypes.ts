@@ -19,6 +19,9 @@ export interface Node {
 	load15?: number;
 	services?: string[];
 	forwarded_for_header?: string;
+	bastion_port?: number;
+	bastion_authorities?: string[];
+	bastion_public_key?: string;
 }
 
 export type Nodes = Node[];
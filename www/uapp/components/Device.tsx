@@ -0,0 +1,151 @@
+/// <reference path="../References.d.ts"/>
+import * as React from 'react';
+import * as SuperAgent from 'superagent';
+import * as Csrf from '../Csrf';
+import * as Alert from '../Alert';
+import Validate from './Validate';
+
+interface DeviceInfo {
+	user_code: string;
+	fingerprint: string;
+	expires: string;
+}
+
+interface Props {
+	userCode: string;
+}
+
+interface State {
+	disabled: boolean;
+	userCode: string;
+	info: DeviceInfo;
+}
+
+const css = {
+	body: {
+		padding: 0,
+	} as React.CSSProperties,
+	description: {
+		opacity: 0.7,
+		padding: '0 10px',
+	} as React.CSSProperties,
+	fingerprint: {
+		opacity: 0.7,
+		padding: '0 10px 10px 10px',
+		textAlign: 'center',
+		wordBreak: 'break-all',
+	} as React.CSSProperties,
+	buttons: {
+		marginTop: '15px',
+	} as React.CSSProperties,
+	input: {
+		margin: '5px auto',
+		width: '75%',
+		textAlign: 'center',
+		textTransform: 'uppercase',
+	} as React.CSSProperties,
+	button: {
+		margin: '5px auto',
+		padding: '8px 15px',
+		width: '75%',
+	} as React.CSSProperties,
+};
+
+export default class Device extends React.Component<Props, State> {
+	constructor(props: any, context: any) {
+		super(props, context);
+		this.state = {
+			disabled: false,
+			userCode: this.props.userCode || '',
+			info: null,
+		};
+	}
+
+	submit(): void {
+		this.setState({
+			...this.state,
+			disabled: true,
+		});
+
+		SuperAgent
+			.get('/ssh/device/' + encodeURIComponent(this.state.userCode))
+			.set('Accept', 'application/json')
+			.set('Csrf-Token', Csrf.token)
+			.end((err: any, res: SuperAgent.Response): void => {
+				this.setState({
+					...this.state,
+					disabled: false,
+				});
+
+				if (res && res.status === 404) {
+					Alert.error('Device code is invalid or has expired', 0);
+					return;
+				} else if (err) {
+					Alert.errorRes(res, 'Failed to load device code', 0);
+					return;
+				}
+
+				this.setState({
+					...this.state,
+					info: res.body,
+				});
+			});
+	}
+
+	render(): JSX.Element {
+		if (this.state.info) {
+			return <div>
+				<Validate token={this.state.info.user_code} device={true}/>
+				<div style={css.fingerprint}>
+					{this.state.info.fingerprint}
+				</div>
+			</div>;
+		}
+
+		return <div>
+			<div className="pt-non-ideal-state" style={css.body}>
+				<div className="pt-non-ideal-state-visual pt-non-ideal-state-icon">
+					<span className="pt-icon pt-icon-mobile-phone"/>
+				</div>
+				<h4 className="pt-non-ideal-state-title">Device Login</h4>
+				<span style={css.description}>
+					Enter the code shown on your device
+				</span>
+			</div>
+			<div className="layout vertical center-justified" style={css.buttons}>
+				<input
+					className="pt-input pt-large"
+					style={css.input}
+					disabled={this.state.disabled}
+					type="text"
+					autoCapitalize="characters"
+					spellCheck={false}
+					placeholder="XXXX-XXXX"
+					value={this.state.userCode}
+					onChange={(evt): void => {
+						this.setState({
+							...this.state,
+							userCode: evt.target.value,
+						});
+					}}
+					onKeyPress={(evt): void => {
+						if (evt.key === 'Enter') {
+							this.submit();
+						}
+					}}
+				/>
+				<button
+					className="pt-button pt-intent-primary"
+					style={css.button}
+					type="button"
+					disabled={this.state.disabled || !this.state.userCode}
+					onClick={(): void => {
+						this.submit();
+					}}
+				>
+					Continue
+				</button>
+			</div>
+		</div>;
+	}
+}
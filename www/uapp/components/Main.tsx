@@ -3,6 +3,7 @@ import * as React from 'react';
 import Session from './Session';
 import Validate from './Validate';
 import Keybase from './Keybase';
+import Device from './Device';
 
 const css = {
 	card: {
@@ -23,6 +24,8 @@ export default class Main extends React.Component<{}, {}> {
 		let sshToken = '';
 		let keybaseToken = '';
 		let keybaseSig = '';
+		let device = false;
+		let userCode = '';
 		let query = window.location.search.substring(1);
 		let vals = query.split('&');
 		for (let val of vals) {
@@ -33,6 +36,10 @@ export default class Main extends React.Component<{}, {}> {
 				keybaseToken = keyval[1];
 			} else if (keyval[0] === 'keybase-sig') {
 				keybaseSig = decodeURIComponent(keyval[1]).replace(/\+/g, ' ');
+			} else if (keyval[0] === 'device') {
+				device = true;
+			} else if (keyval[0] === 'user-code') {
+				userCode = decodeURIComponent(keyval[1]);
 			}
 		}
 
@@ -40,6 +47,8 @@ export default class Main extends React.Component<{}, {}> {
 
 		if (sshToken) {
 			bodyElm = <Validate token={sshToken}/>;
+		} else if (device) {
+			bodyElm = <Device userCode={userCode}/>;
 		} else if (keybaseToken && keybaseSig) {
 			bodyElm = <Keybase token={keybaseToken} signature={keybaseSig}/>
 		} else {
@@ -23,6 +23,7 @@ interface SecondaryState {
 
 interface Props {
 	token: string;
+	device?: boolean;
 }
 
 interface State {
@@ -71,6 +72,13 @@ export default class Validate extends React.Component<Props, State> {
 		};
 	}
 
+	path(): string {
+		if (this.props.device) {
+			return '/ssh/device/' + this.props.token;
+		}
+		return '/ssh/validate/' + this.props.token;
+	}
+
 	secondarySubmit(factor: string): void {
 		let passcode = '';
 		if (factor === 'passcode') {
@@ -275,7 +283,7 @@ export default class Validate extends React.Component<Props, State> {
 						});
 
 						SuperAgent
-							.put('/ssh/validate/' + this.props.token)
+							.put(this.path())
 							.set('Accept', 'application/json')
 							.set('Csrf-Token', Csrf.token)
 							.end((err: any, res: SuperAgent.Response): void => {
@@ -332,7 +340,7 @@ export default class Validate extends React.Component<Props, State> {
 						});
 
 						SuperAgent
-							.delete('/ssh/validate/' + this.props.token)
+							.delete(this.path())
 							.set('Accept', 'application/json')
 							.set('Csrf-Token', Csrf.token)
 							.end((err: any, res: SuperAgent.Response): void => {